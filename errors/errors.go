@@ -0,0 +1,180 @@
+// Package errors provides typed errors that classify failures into categories
+// that can be mapped onto transport status codes, such as HTTP statuses.
+//
+// Errors returned by dorky packages are *Error values. Use errors.As from the
+// standard library, or CategoryOf, to inspect them.
+package errors
+
+import (
+	"errors"
+	"maps"
+)
+
+// Category classifies an Error by the kind of failure it represents
+type Category int
+
+const (
+	// Internal errors are unexpected failures. Errors that aren't an *Error
+	// are considered Internal.
+	Internal Category = iota
+
+	// NotFound errors indicate that a requested resource doesn't exist
+	NotFound
+
+	// Conflict errors indicate that a request conflicts with the current
+	// state, e.g. a duplicate entity or an invalid state transition
+	Conflict
+
+	// Invalid errors indicate that a request is malformed or not valid
+	Invalid
+
+	// Forbidden errors indicate that a request isn't permitted
+	Forbidden
+
+	// Unavailable errors indicate that a request couldn't be completed at
+	// this time, but may succeed if retried
+	Unavailable
+)
+
+var categoryNames = map[Category]string{
+	Internal:    "internal",
+	NotFound:    "not_found",
+	Conflict:    "conflict",
+	Invalid:     "invalid",
+	Forbidden:   "forbidden",
+	Unavailable: "unavailable",
+}
+
+func (c Category) String() string {
+	if name, ok := categoryNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Error is a categorized error. Code is a stable, machine readable identifier
+// for the specific failure, and Details carry structured information about
+// it. An Error may wrap an underlying error.
+type Error struct {
+	Category Category
+	Code     string
+	Message  string
+	Details  map[string]any
+	Err      error
+}
+
+// New creates an Error with the category, code and message provided
+func New(category Category, code string, message string) *Error {
+	return &Error{Category: category, Code: code, Message: message}
+}
+
+// Wrap creates an Error with the category, code and message provided that
+// wraps err
+func Wrap(err error, category Category, code string, message string) *Error {
+	return &Error{Category: category, Code: code, Message: message, Err: err}
+}
+
+func NewNotFound(code string, message string) *Error {
+	return New(NotFound, code, message)
+}
+
+func NewConflict(code string, message string) *Error {
+	return New(Conflict, code, message)
+}
+
+func NewInvalid(code string, message string) *Error {
+	return New(Invalid, code, message)
+}
+
+func NewForbidden(code string, message string) *Error {
+	return New(Forbidden, code, message)
+}
+
+func NewUnavailable(code string, message string) *Error {
+	return New(Unavailable, code, message)
+}
+
+func NewInternal(code string, message string) *Error {
+	return New(Internal, code, message)
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err == nil:
+		return e.Message
+	case e.Message == "":
+		return e.Err.Error()
+	default:
+		return e.Message + ": " + e.Err.Error()
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an *Error with the same category and code, so
+// that annotated copies of a sentinel Error still match it with errors.Is
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+
+	if !ok || t.Code == "" {
+		return false
+	}
+
+	return e.Category == t.Category && e.Code == t.Code
+}
+
+// WithDetail returns a copy of the Error with the detail added, leaving the
+// original unchanged so that sentinel Errors can be safely annotated
+func (e *Error) WithDetail(key string, value any) *Error {
+	detailed := *e
+	detailed.Details = maps.Clone(e.Details)
+
+	if detailed.Details == nil {
+		detailed.Details = make(map[string]any)
+	}
+
+	detailed.Details[key] = value
+
+	return &detailed
+}
+
+// Wrap returns a copy of the Error that wraps err, leaving the original
+// unchanged
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// CategoryOf returns the Category of the first *Error in err's chain, or
+// Internal if there isn't one. CategoryOf returns Internal for a nil err.
+func CategoryOf(err error) Category {
+	var e *Error
+
+	if errors.As(err, &e) {
+		return e.Category
+	}
+
+	return Internal
+}
+
+// CodeOf returns the code of the first *Error in err's chain, or an empty
+// string if there isn't one
+func CodeOf(err error) string {
+	var e *Error
+
+	if errors.As(err, &e) {
+		return e.Code
+	}
+
+	return ""
+}
+
+// HasCategory determines if err's chain contains an *Error with the category
+// provided
+func HasCategory(err error, category Category) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Category == category
+}
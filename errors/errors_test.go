@@ -0,0 +1,75 @@
+package errors_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/inmem"
+	"github.com/dmpettyp/dorky/mapper"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"nil", nil, http.StatusOK},
+		{"plain error", errors.New("boom"), http.StatusInternalServerError},
+		{"not found", inmem.ErrNotFound, http.StatusNotFound},
+		{"conflict", inmem.ErrAlreadyExists, http.StatusConflict},
+		{"invalid", dorkyerrors.NewInvalid("bad", "bad"), http.StatusBadRequest},
+		{"forbidden", dorkyerrors.NewForbidden("no", "no"), http.StatusForbidden},
+		{"unavailable", dorkyerrors.NewUnavailable("down", "down"), http.StatusServiceUnavailable},
+		{"wrapped", fmt.Errorf("finding: %w", inmem.ErrNotFound), http.StatusNotFound},
+		{"mapper", mapper.ErrNoMapping, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := dorkyerrors.HTTPStatus(tt.err); status != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
+
+func TestWrappingAndDetails(t *testing.T) {
+	err := inmem.ErrNotFound.WithDetail("id", "123").Wrap(context.Canceled)
+
+	if !errors.Is(err, inmem.ErrNotFound) {
+		t.Error("expected annotated copy to match its sentinel")
+	}
+
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expected wrapped error to be in the chain")
+	}
+
+	if err.Error() != "entity not found: context canceled" {
+		t.Errorf("unexpected error message: %v", err)
+	}
+
+	if err.Details["id"] != "123" {
+		t.Errorf("expected detail to be set, got %v", err.Details)
+	}
+
+	if inmem.ErrNotFound.Details != nil {
+		t.Error("expected sentinel to be unchanged")
+	}
+
+	if errors.Is(err, inmem.ErrAlreadyExists) {
+		t.Error("expected errors with different codes not to match")
+	}
+
+	if code := dorkyerrors.CodeOf(fmt.Errorf("wrapped: %w", err)); code != "entity_not_found" {
+		t.Errorf("unexpected code %q", code)
+	}
+
+	if !dorkyerrors.HasCategory(err, dorkyerrors.NotFound) {
+		t.Error("expected error to have the NotFound category")
+	}
+}
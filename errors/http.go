@@ -0,0 +1,26 @@
+package errors
+
+import "net/http"
+
+var httpStatuses = map[Category]int{
+	Internal:    http.StatusInternalServerError,
+	NotFound:    http.StatusNotFound,
+	Conflict:    http.StatusConflict,
+	Invalid:     http.StatusBadRequest,
+	Forbidden:   http.StatusForbidden,
+	Unavailable: http.StatusServiceUnavailable,
+}
+
+// HTTPStatus returns the HTTP status code for err based on its Category. A nil
+// err maps to http.StatusOK.
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	if status, ok := httpStatuses[CategoryOf(err)]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,88 @@
+package inmem
+
+import (
+	"iter"
+	"slices"
+)
+
+// All returns an iterator over the entities in the repository that match,
+// or all of them if matchFn is nil. Entities are looked up lazily as the
+// iteration proceeds, so an iteration that stops early doesn't visit the
+// remaining entities.
+//
+// Like FindAll, the entities yielded are added to the repository's
+// Transaction collection, but only once they are yielded. The repository
+// isn't locked while the loop body runs, so it may use the repository,
+// though entities it adds or saves may not be yielded.
+//
+// For in-memory repositories, the only error yielded is the one returned when
+// a repository is used directly during a UnitOfWork Run. The error lets
+// repositories backed by other stores report failures as they iterate.
+func (repo *Repository[Entity]) All(matchFn func(Entity) bool) iter.Seq2[Entity, error] {
+	matches := func(entity Entity) bool {
+		return matchFn == nil || matchFn(entity)
+	}
+
+	return func(yield func(Entity, error) bool) {
+		if err := repo.checkDirectUse(); err != nil {
+			var zero Entity
+			yield(zero, err)
+			return
+		}
+
+		repo.mu.Lock()
+		transaction := slices.Clone(repo.Transaction)
+		repo.mu.Unlock()
+
+		for _, entity := range transaction {
+			repo.mu.Lock()
+			found := repo.inTransaction(entity) && repo.visible(entity) && matches(entity)
+			repo.mu.Unlock()
+
+			if found && !yield(entity, nil) {
+				return
+			}
+		}
+
+		for position := 0; ; position++ {
+			entity, ok := repo.nextPersisted(&position, matches)
+
+			if !ok {
+				return
+			}
+
+			if !yield(entity, nil) {
+				return
+			}
+		}
+	}
+}
+
+// nextPersisted adds the first persisted entity that matches, starting from
+// position, to the repository's Transaction collection and returns it. The
+// position of the entity is stored in position.
+func (repo *Repository[Entity]) nextPersisted(
+	position *int,
+	matches func(Entity) bool,
+) (
+	Entity,
+	bool,
+) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	entities := repo.store().Entities
+
+	for ; *position < len(entities); *position++ {
+		entity := entities[*position]
+
+		if !repo.visiblePersisted(entity) || repo.inTransaction(entity) || !matches(entity) {
+			continue
+		}
+
+		return repo.enlist(entity), true
+	}
+
+	var zero Entity
+	return zero, false
+}
@@ -0,0 +1,66 @@
+package inmem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that iterating over entities only visits and enlists the entities
+// consumed
+func TestRepositoryAll(t *testing.T) {
+	repo := newMemberRepository(t)
+	repo.Entities = []*member{
+		{ID: 1, Email: "ada@example.com", Team: "core"},
+		{ID: 2, Email: "bob@example.com", Team: "web"},
+		{ID: 3, Email: "cy@example.com", Team: "core"},
+		{ID: 4, Email: "dan@example.com", Team: "core"},
+		{ID: 5, Email: "eve@example.com", Team: "core"},
+	}
+
+	m, err := repo.FindByKey(2)
+	require.NoError(t, err)
+	m.Team = "core"
+
+	matched := 0
+	core := func(m *member) bool {
+		matched++
+		return m.Team == "core"
+	}
+
+	var ids []int
+
+	for m, err := range repo.All(core) {
+		require.NoError(t, err)
+		ids = append(ids, m.ID)
+
+		if len(ids) == 2 {
+			break
+		}
+	}
+
+	require.Equal(t, []int{2, 1}, ids)
+	require.Equal(t, 2, matched)
+	require.Len(t, repo.Transaction, 2)
+	require.Same(t, m, repo.Transaction[0])
+
+	repo.Reset()
+
+	// The repository can be used while iterating
+	ids = nil
+
+	for m, err := range repo.All(nil) {
+		require.NoError(t, err)
+		ids = append(ids, m.ID)
+
+		if m.ID == 1 {
+			require.NoError(t, repo.Remove(&member{ID: 3}))
+		}
+	}
+
+	require.Equal(t, []int{1, 2, 4, 5}, ids)
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+	require.Len(t, repo.Entities, 4)
+}
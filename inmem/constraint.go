@@ -0,0 +1,208 @@
+package inmem
+
+import "slices"
+
+// defaultConstraint names the constraint defined by the constraintEqualFn
+// provided to CreateRepository
+const defaultConstraint = "default"
+
+// keyConstraint names the constraint that no two entities in a repository
+// created with NewRepository have the same key
+const keyConstraint = "key"
+
+// constraint requires that no two entities of a repository are equal
+// according to equalFn
+type constraint[Entity any] struct {
+	name    string
+	equalFn func(Entity, Entity) bool
+}
+
+// WithConstraint adds a uniqueness constraint named name to the repository,
+// which requires that no two of its entities are equal according to equalFn.
+// Adding or saving an entity that violates the constraint returns a
+// *ConstraintError naming it.
+//
+// Constraints on a single value of the entities are better declared with
+// WithUniqueIndex, which doesn't compare entities to every persisted entity.
+func WithConstraint[Entity entity[Entity]](
+	name string,
+	equalFn func(Entity, Entity) bool,
+) RepositoryOption[Entity] {
+	return func(config *repositoryConfig[Entity]) {
+		config.constraints = append(
+			config.constraints, constraint[Entity]{name: name, equalFn: equalFn},
+		)
+	}
+}
+
+// WithIdentity identifies the entities of a repository created with
+// CreateRepository by the value returned by identityFn in the
+// *ConstraintErrors it returns, and looks entities up by it rather than by
+// comparing them with identityEqualFn, which it must agree with. Entities of
+// repositories created with NewRepository are identified by their keys.
+func WithIdentity[Entity entity[Entity], K comparable](
+	identityFn func(Entity) K,
+) RepositoryOption[Entity] {
+	return func(config *repositoryConfig[Entity]) {
+		if identityFn != nil {
+			config.identityFn = func(entity Entity) any { return identityFn(entity) }
+		}
+	}
+}
+
+// uniqueEntities holds entities by their keys, and by their values for the
+// unique indexes of the repository unless values is nil, so that entities are
+// checked against them for those constraints without comparing entities
+type uniqueEntities[Entity entity[Entity]] struct {
+	keys   map[any]Entity
+	values map[*index[Entity]]map[any]Entity
+}
+
+// newUniqueEntities returns an empty uniqueEntities holding values for the
+// unique indexes of the repository
+func (repo *Repository[Entity]) newUniqueEntities() *uniqueEntities[Entity] {
+	unique := &uniqueEntities[Entity]{
+		keys:   make(map[any]Entity),
+		values: make(map[*index[Entity]]map[any]Entity),
+	}
+
+	for _, idx := range repo.indexes {
+		if idx.unique {
+			unique.values[idx] = make(map[any]Entity)
+		}
+	}
+
+	return unique
+}
+
+// addUnique adds an entity to uniqueEntities of the repository
+func (repo *Repository[Entity]) addUnique(unique *uniqueEntities[Entity], entity Entity) {
+	if repo.keyFn != nil {
+		unique.keys[repo.keyFn(entity)] = entity
+	}
+
+	for idx, values := range unique.values {
+		values[idx.keyFn(entity)] = entity
+	}
+}
+
+// conflict returns the name of the constraint that prevents two entities
+// from both being stored in the repository, or an empty string if they can
+// be. The key constraint, and the unique indexes when indexes is false,
+// aren't checked.
+func (repo *Repository[Entity]) conflict(a Entity, b Entity, indexes bool) string {
+	for _, c := range repo.constraints {
+		if c.equalFn(a, b) {
+			return c.name
+		}
+	}
+
+	for _, idx := range repo.indexes {
+		if indexes && idx.unique && idx.keyFn(a) == idx.keyFn(b) {
+			return idx.name
+		}
+	}
+
+	return ""
+}
+
+// checkConstraints verifies that an entity can be stored alongside others
+// and the persisted entities, other than those at the replaced positions.
+// unique holds the entities of others, which are only compared to the entity
+// for the constraints it can't check. A *ConstraintError is returned for the
+// first conflicting entity found.
+func (repo *Repository[Entity]) checkConstraints(
+	toSave Entity,
+	others []Entity,
+	unique *uniqueEntities[Entity],
+	replaced map[int]bool,
+) error {
+	if repo.keyFn != nil {
+		if other, ok := unique.keys[repo.keyFn(toSave)]; ok {
+			return repo.constraintError(keyConstraint, other)
+		}
+	}
+
+	for _, idx := range repo.indexes {
+		if other, ok := unique.values[idx][idx.keyFn(toSave)]; ok {
+			return repo.constraintError(idx.name, other)
+		}
+	}
+
+	compareIndexes := unique.values == nil
+
+	if len(repo.constraints) > 0 || (compareIndexes && repo.hasUniqueIndexes()) {
+		for _, other := range others {
+			if name := repo.conflict(toSave, other, compareIndexes); name != "" {
+				return repo.constraintError(name, other)
+			}
+		}
+	}
+
+	if repo.keyFn != nil {
+		position, ok := repo.positionOfKey(repo.keyFn(toSave))
+
+		if ok && !replaced[position] {
+			return repo.constraintError(keyConstraint, repo.store().Entities[position])
+		}
+	}
+
+	for _, c := range repo.constraints {
+		for position, entity := range repo.store().Entities {
+			if !replaced[position] && c.equalFn(toSave, entity) {
+				return repo.constraintError(c.name, entity)
+			}
+		}
+	}
+
+	for _, idx := range repo.indexes {
+		if !idx.unique {
+			continue
+		}
+
+		for _, position := range repo.positionsOfValue(idx, idx.keyFn(toSave)) {
+			if !replaced[position] {
+				return repo.constraintError(idx.name, repo.store().Entities[position])
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasUniqueIndexes determines if the repository has unique indexes
+func (repo *Repository[Entity]) hasUniqueIndexes() bool {
+	return slices.ContainsFunc(repo.indexes, func(idx *index[Entity]) bool {
+		return idx.unique
+	})
+}
+
+// removedPositions returns the positions of the persisted entities removed
+// in the transaction
+func (repo *Repository[Entity]) removedPositions() map[int]bool {
+	positions := make(map[int]bool, len(repo.removed.entities))
+
+	for _, entity := range repo.removed.entities {
+		if position, ok := repo.position(entity); ok {
+			positions[position] = true
+		}
+	}
+
+	return positions
+}
+
+// constraintError returns the *ConstraintError for a conflict with an
+// entity. The entity is copied so that the error doesn't share the persisted
+// entity.
+func (repo *Repository[Entity]) constraintError(name string, conflicting Entity) error {
+	err := &ConstraintError{Constraint: name, Entity: conflicting.Clone()}
+
+	switch {
+	case repo.keyFn != nil:
+		err.Identity = repo.keyFn(conflicting)
+	case repo.identityFn != nil:
+		err.Identity = repo.identityFn(conflicting)
+	}
+
+	return err
+}
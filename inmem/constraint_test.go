@@ -0,0 +1,144 @@
+package inmem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/aggregate"
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/inmem"
+)
+
+type page struct {
+	aggregate.Aggregate
+	ID     int
+	Tenant string
+	Slug   string
+	Title  string
+}
+
+func (p *page) Clone() *page {
+	clone := *p
+	return &clone
+}
+
+func newPageRepository(t *testing.T) *inmem.Repository[*page] {
+	repo, err := inmem.NewRepository(
+		func(p *page) int { return p.ID },
+		inmem.WithConstraint("slug_per_tenant", func(a, b *page) bool {
+			return a.Tenant == b.Tenant && a.Slug == b.Slug
+		}),
+		inmem.WithUniqueIndex("title", func(p *page) string { return p.Title }),
+	)
+	require.NoError(t, err)
+	return &repo
+}
+
+func requireConstraintError(t *testing.T, err error, constraint string, identity any) {
+	t.Helper()
+
+	var constraintErr *inmem.ConstraintError
+	require.ErrorAs(t, err, &constraintErr)
+	require.Equal(t, constraint, constraintErr.Constraint)
+	require.Equal(t, identity, constraintErr.Identity)
+
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+	require.Equal(t, "entity_already_exists", dorkyerrors.CodeOf(err))
+}
+
+// Test that constraint violations name the constraint and the conflicting
+// entity
+func TestRepositoryConstraints(t *testing.T) {
+	repo := newPageRepository(t)
+	repo.Entities = []*page{
+		{ID: 1, Tenant: "acme", Slug: "home", Title: "Acme"},
+		{ID: 2, Tenant: "acme", Slug: "about", Title: "About Acme"},
+	}
+
+	err := repo.Add(&page{ID: 3, Tenant: "acme", Slug: "home", Title: "Home"})
+	requireConstraintError(t, err, "slug_per_tenant", 1)
+
+	err = repo.Add(&page{ID: 3, Tenant: "initech", Slug: "home", Title: "Acme"})
+	requireConstraintError(t, err, "title", 1)
+
+	err = repo.Add(&page{ID: 2, Tenant: "initech", Slug: "about", Title: "About"})
+	requireConstraintError(t, err, "key", 2)
+
+	require.NoError(t, repo.Add(&page{ID: 3, Tenant: "initech", Slug: "home", Title: "Initech"}))
+
+	err = repo.Add(&page{ID: 4, Tenant: "initech", Slug: "home", Title: "Home"})
+	requireConstraintError(t, err, "slug_per_tenant", 3)
+
+	// Entities added in the same transaction are checked against each other
+	// when they are saved
+	added := &page{ID: 4, Tenant: "initech", Slug: "about", Title: "About Initech"}
+	require.NoError(t, repo.Add(added))
+	added.Slug = "home"
+
+	_, err = repo.Save()
+	requireConstraintError(t, err, "slug_per_tenant", 3)
+	require.Len(t, repo.Entities, 2)
+
+	repo.Reset()
+
+	// Entities that swap values in the same transaction don't conflict
+	pages, err := repo.FindAll(func(p *page) bool { return p.Tenant == "acme" })
+	require.NoError(t, err)
+	require.Len(t, pages, 2)
+
+	pages[0].Slug, pages[1].Slug = pages[1].Slug, pages[0].Slug
+	pages[0].Title, pages[1].Title = pages[1].Title, pages[0].Title
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+	require.Equal(t, "about", repo.Entities[0].Slug)
+	require.Equal(t, "Acme", repo.Entities[1].Title)
+}
+
+// Test that the constraintEqualFn of CreateRepository is named "default", and
+// that conflicting entities are identified by the identityFn given
+// WithIdentity
+func TestRepositoryDefaultConstraint(t *testing.T) {
+	repo := newDocumentRepository(t)
+	persisted := &document{ID: "d1", Title: "draft"}
+	repo.Entities = []*document{persisted}
+
+	err := repo.Add(&document{ID: "d2", Title: "draft"})
+	requireConstraintError(t, err, "default", nil)
+	require.EqualError(t, err, `entity already exists: violates constraint "default"`)
+
+	// The error holds a copy of the conflicting entity
+	var constraintErr *inmem.ConstraintError
+	require.ErrorAs(t, err, &constraintErr)
+	require.Equal(t, persisted, constraintErr.Entity)
+	require.NotSame(t, persisted, constraintErr.Entity)
+
+	identified, err := inmem.CreateRepository(
+		func(a, b *document) bool { return a.ID == b.ID },
+		func(a, b *document) bool { return a.Title == b.Title },
+		inmem.WithIdentity(func(d *document) string { return d.ID }),
+	)
+	require.NoError(t, err)
+	identified.Entities = []*document{persisted}
+
+	err = identified.Add(&document{ID: "d2", Title: "draft"})
+	requireConstraintError(t, err, "default", "d1")
+	require.EqualError(t, err, `entity already exists: violates constraint "default" with d1`)
+}
+
+// Test that invalid constraints are rejected
+func TestRepositoryInvalidConstraints(t *testing.T) {
+	_, err := inmem.NewRepository(
+		func(p *page) int { return p.ID },
+		inmem.WithConstraint[*page]("slug", nil),
+	)
+	require.Equal(t, "invalid_repository", dorkyerrors.CodeOf(err))
+
+	_, err = inmem.NewRepository(
+		func(p *page) int { return p.ID },
+		inmem.WithConstraint("slug", func(a, b *page) bool { return a.Slug == b.Slug }),
+		inmem.WithUniqueIndex("slug", func(p *page) string { return p.Slug }),
+	)
+	require.Equal(t, "invalid_repository", dorkyerrors.CodeOf(err))
+}
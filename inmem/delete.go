@@ -0,0 +1,150 @@
+package inmem
+
+import (
+	"slices"
+)
+
+// SoftDeletable is implemented by entities stored in repositories created
+// WithSoftDelete
+type SoftDeletable interface {
+	// MarkDeleted marks the entity as deleted
+	MarkDeleted()
+
+	// IsDeleted determines if the entity has been marked as deleted
+	IsDeleted() bool
+}
+
+// WithSoftDelete configures the repository to mark removed entities as
+// deleted rather than deleting them. Entities marked as deleted are kept in
+// Entities, where they continue to hold their keys and unique index values,
+// but are no longer found by the repository. The repository's entities must
+// implement SoftDeletable.
+func WithSoftDelete[Entity entity[Entity]]() RepositoryOption[Entity] {
+	return func(config *repositoryConfig[Entity]) {
+		config.softDelete = true
+	}
+}
+
+// Remove removes the entity with the same identity as the entity provided
+// from the repository. The removal is staged in the repository's transaction
+// and applied when it is saved, along with its other changes.
+//
+// Events recorded by the entity are committed when the removal is saved. When
+// an entity with the same identity is in the repository's Transaction
+// collection, as it is when the entity was returned by one of the
+// repository's finds, that entity is removed. Otherwise a copy of the entity
+// provided is removed.
+//
+// ErrNotFound is returned if there is no such entity.
+func (repo *Repository[Entity]) Remove(toRemove Entity) error {
+	if err := repo.checkDirectUse(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.remove(toRemove)
+}
+
+// RemoveWhere removes all of the entities that match like Remove, returning
+// the number of entities removed
+func (repo *Repository[Entity]) RemoveWhere(matchFn func(Entity) bool) (int, error) {
+	if err := repo.checkDirectUse(); err != nil {
+		return 0, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var toRemove []Entity
+
+	for _, entity := range repo.Transaction {
+		if repo.visible(entity) && matchFn(entity) {
+			toRemove = append(toRemove, entity)
+		}
+	}
+
+	for _, entity := range repo.store().Entities {
+		if !repo.visiblePersisted(entity) || repo.inTransaction(entity) || !matchFn(entity) {
+			continue
+		}
+
+		toRemove = append(toRemove, entity)
+	}
+
+	for _, entity := range toRemove {
+		if err := repo.remove(entity); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(toRemove), nil
+}
+
+func (repo *Repository[Entity]) remove(toRemove Entity) error {
+	entity, ok := repo.transactionEntity(toRemove)
+
+	if ok && !repo.visible(entity) {
+		return ErrNotFound
+	}
+
+	if !ok {
+		position, ok := repo.position(toRemove)
+
+		if !ok || !repo.visiblePersisted(repo.store().Entities[position]) {
+			return ErrNotFound
+		}
+
+		// The entity may be persisted, so a copy is removed to ensure that
+		// the persisted entities aren't changed until the repo is saved
+		entity = toRemove.Clone()
+		repo.appendTransaction(entity)
+	}
+
+	if repo.softDelete {
+		any(entity).(SoftDeletable).MarkDeleted()
+		return nil
+	}
+
+	repo.addTo(&repo.removed, entity)
+
+	// The position of the entity is only needed to delete it from the
+	// Transaction collection
+	i := slices.IndexFunc(repo.Transaction, func(e Entity) bool {
+		return repo.identityEqual(e, entity)
+	})
+	repo.deleteTransaction(i)
+
+	return nil
+}
+
+// visible determines if an entity can be found in the repository, which it
+// can't once it has been soft deleted
+func (repo *Repository[Entity]) visible(entity Entity) bool {
+	return !repo.softDelete || !any(entity).(SoftDeletable).IsDeleted()
+}
+
+// visiblePersisted determines if a persisted entity can be found in the
+// repository, which it also can't once it has been removed in the
+// transaction
+func (repo *Repository[Entity]) visiblePersisted(entity Entity) bool {
+	return repo.visible(entity) && !repo.isRemoved(entity)
+}
+
+// isRemoved determines if the entity has been removed in the transaction of
+// a repository that doesn't soft delete
+func (repo *Repository[Entity]) isRemoved(entity Entity) bool {
+	_, ok := repo.findIn(&repo.removed, entity)
+	return ok
+}
+
+// deleteRemoved deletes the entities that have been removed from Entities
+func (repo *Repository[Entity]) deleteRemoved() {
+	if len(repo.removed.entities) == 0 {
+		return
+	}
+
+	store := repo.store()
+	store.Entities = slices.DeleteFunc(store.Entities, repo.isRemoved)
+}
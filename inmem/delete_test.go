@@ -0,0 +1,163 @@
+package inmem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/aggregate"
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/inmem"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+type memberDeletedEvent struct {
+	messages.BaseEvent
+}
+
+func deleteMember(m *member) {
+	evt := &memberDeletedEvent{}
+	evt.Init("MemberDeleted")
+	m.AddEvent(evt)
+}
+
+// Test that removals are staged until the repository is saved
+func TestRepositoryRemove(t *testing.T) {
+	repo := newMemberRepository(t)
+	repo.Entities = []*member{
+		{ID: 1, Email: "ada@example.com", Team: "core"},
+		{ID: 2, Email: "bob@example.com", Team: "core"},
+		{ID: 3, Email: "cy@example.com", Team: "web"},
+	}
+
+	m, err := repo.FindByKey(1)
+	require.NoError(t, err)
+
+	deleteMember(m)
+	require.NoError(t, repo.Remove(m))
+
+	_, err = repo.FindByKey(1)
+	require.ErrorIs(t, err, inmem.ErrNotFound)
+
+	require.ErrorIs(t, repo.Remove(m), inmem.ErrNotFound)
+	require.Len(t, repo.Entities, 3)
+
+	// Reset discards removals
+	repo.Reset()
+
+	m, err = repo.FindByKey(1)
+	require.NoError(t, err)
+	require.Empty(t, m.GetEvents())
+
+	deleteMember(m)
+	require.NoError(t, repo.Remove(m))
+
+	removed, err := repo.RemoveWhere(func(m *member) bool { return m.Team == "core" })
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	events, err := repo.Save()
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "MemberDeleted", events[0].GetType())
+
+	require.Len(t, repo.Entities, 1)
+	require.Equal(t, 3, repo.Entities[0].ID)
+
+	m, err = repo.FindOneByIndex("email", "cy@example.com")
+	require.NoError(t, err)
+	require.Equal(t, 3, m.ID)
+
+	// An entity can be added in place of one removed in the same transaction
+	require.NoError(t, repo.Remove(m))
+	require.NoError(t, repo.Add(&member{ID: 3, Email: "cy@example.com", Team: "ops"}))
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+	require.Len(t, repo.Entities, 1)
+	require.Equal(t, "ops", repo.Entities[0].Team)
+
+	require.ErrorIs(t, repo.Remove(&member{ID: 4}), inmem.ErrNotFound)
+}
+
+// Test that removals aren't applied when the repository can't be saved
+func TestRepositoryRemoveAtomic(t *testing.T) {
+	repo := newMemberRepository(t)
+	repo.Entities = []*member{
+		{ID: 1, Email: "ada@example.com"},
+		{ID: 2, Email: "bob@example.com"},
+	}
+
+	require.NoError(t, repo.Remove(&member{ID: 1}))
+
+	m, err := repo.FindByKey(2)
+	require.NoError(t, err)
+
+	// Conflicts with an existing member that isn't being removed
+	repo.Entities = append(repo.Entities, &member{ID: 3, Email: "cy@example.com"})
+	m.Email = "cy@example.com"
+
+	_, err = repo.Save()
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+	require.Len(t, repo.Entities, 3)
+}
+
+type project struct {
+	aggregate.Aggregate
+	ID      string
+	Deleted bool
+}
+
+func (p *project) Clone() *project {
+	clone := *p
+	return &clone
+}
+
+func (p *project) MarkDeleted() {
+	p.Deleted = true
+}
+
+func (p *project) IsDeleted() bool {
+	return p.Deleted
+}
+
+// Test that soft deleted entities are kept but can't be found
+func TestRepositorySoftDelete(t *testing.T) {
+	repo, err := inmem.NewRepository(
+		func(p *project) string { return p.ID },
+		inmem.WithSoftDelete[*project](),
+	)
+	require.NoError(t, err)
+
+	repo.Entities = []*project{{ID: "p1"}, {ID: "p2"}}
+
+	p, err := repo.FindByKey("p1")
+	require.NoError(t, err)
+	require.NoError(t, repo.Remove(p))
+
+	_, err = repo.FindByKey("p1")
+	require.ErrorIs(t, err, inmem.ErrNotFound)
+
+	// The persisted entity isn't marked until the repository is saved
+	require.False(t, repo.Entities[0].Deleted)
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+
+	require.Len(t, repo.Entities, 2)
+	require.True(t, repo.Entities[0].Deleted)
+
+	all, err := repo.FindAll(func(p *project) bool { return true })
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.Equal(t, "p2", all[0].ID)
+
+	// Soft deleted entities keep their keys
+	require.ErrorIs(t, repo.Add(&project{ID: "p1"}), inmem.ErrAlreadyExists)
+
+	_, err = inmem.NewRepository(
+		func(m *member) int { return m.ID },
+		inmem.WithSoftDelete[*member](),
+	)
+	require.Equal(t, "invalid_repository", dorkyerrors.CodeOf(err))
+}
@@ -0,0 +1,130 @@
+package inmem
+
+import "reflect"
+
+// MarkDirty marks an entity in the repository's Transaction collection as
+// modified so that it is persisted when the repository is saved.
+//
+// Entities in the Transaction collection are only validated and persisted
+// when they are modified, which is detected by comparing them to the
+// persisted entities they were loaded from. MarkDirty is needed when a
+// modification can't be detected that way, such as when the entity's Clone
+// method shares data between the entity and its copy.
+//
+// ErrNotFound is returned if the entity isn't in the Transaction collection.
+func (repo *Repository[Entity]) MarkDirty(entity Entity) error {
+	if err := repo.checkDirectUse(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if !repo.inTransaction(entity) {
+		return ErrNotFound
+	}
+
+	if !repo.isDirty(entity) {
+		repo.addTo(&repo.dirty, entity)
+	}
+
+	return nil
+}
+
+// isDirty determines if the entity has been marked as modified with
+// MarkDirty
+func (repo *Repository[Entity]) isDirty(entity Entity) bool {
+	_, ok := repo.findIn(&repo.dirty, entity)
+	return ok
+}
+
+// changedEntities returns the entities in the repository's Transaction
+// collection that must be persisted, which are those that were added, marked
+// dirty, or differ from the persisted entity they were copied from. Entities
+// are compared to the persisted entity as it was when it was copied, so that
+// an entity that wasn't changed doesn't replace changes saved since.
+func (repo *Repository[Entity]) changedEntities() []Entity {
+	var changed []Entity
+
+	for _, entity := range repo.Transaction {
+		loaded, ok := repo.loadedFrom(entity)
+
+		if !ok ||
+			repo.isRemoved(entity) ||
+			repo.isDirty(entity) ||
+			!reflect.DeepEqual(loaded, entity) {
+			changed = append(changed, entity)
+		}
+	}
+
+	return changed
+}
+
+// loadedFrom returns the persisted entity that an entity in the repository's
+// Transaction collection was copied from
+func (repo *Repository[Entity]) loadedFrom(entity Entity) (Entity, bool) {
+	return repo.findIn(&repo.loaded, entity)
+}
+
+// FindOneReadOnly uses the provided match function to look for an entity in
+// the repository like FindOne, but without adding it to the repository's
+// Transaction collection. A copy of the entity is returned, so changes made
+// to it are never persisted.
+func (repo *Repository[Entity]) FindOneReadOnly(
+	matchFn func(Entity) bool,
+) (
+	Entity,
+	error,
+) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, entity := range repo.Transaction {
+		if repo.visible(entity) && matchFn(entity) {
+			return entity.Clone(), nil
+		}
+	}
+
+	for _, entity := range repo.store().Entities {
+		if !repo.visiblePersisted(entity) || repo.inTransaction(entity) || !matchFn(entity) {
+			continue
+		}
+
+		return entity.Clone(), nil
+	}
+
+	var zero Entity
+	return zero, ErrNotFound
+}
+
+// FindAllReadOnly uses the provided match function to look for all entities
+// in the repository like FindAll, but without adding them to the
+// repository's Transaction collection. Copies of the entities are returned,
+// so changes made to them are never persisted.
+func (repo *Repository[Entity]) FindAllReadOnly(
+	matchFn func(Entity) bool,
+) (
+	[]Entity,
+	error,
+) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var all []Entity
+
+	for _, entity := range repo.Transaction {
+		if repo.visible(entity) && matchFn(entity) {
+			all = append(all, entity.Clone())
+		}
+	}
+
+	for _, entity := range repo.store().Entities {
+		if !repo.visiblePersisted(entity) || repo.inTransaction(entity) || !matchFn(entity) {
+			continue
+		}
+
+		all = append(all, entity.Clone())
+	}
+
+	return all, nil
+}
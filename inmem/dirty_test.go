@@ -0,0 +1,99 @@
+package inmem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/inmem"
+)
+
+// Test that only entities modified since they were loaded are validated and
+// persisted
+func TestRepositorySavesChangedEntities(t *testing.T) {
+	repo := newDocumentRepository(t)
+
+	// The documents break the repository's constraint, which is only
+	// detected when one of them is saved
+	draft := &document{ID: "d1", Title: "draft", Version: 1}
+	copied := &document{ID: "d2", Title: "draft", Version: 1}
+	repo.Entities = []*document{draft, copied}
+
+	docs, err := repo.FindAll(func(d *document) bool { return true })
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+	require.Same(t, draft, repo.Entities[0])
+	require.Same(t, copied, repo.Entities[1])
+	require.Equal(t, 1, repo.Entities[0].Version)
+
+	docs, err = repo.FindAll(func(d *document) bool { return true })
+	require.NoError(t, err)
+
+	// Changes that are undone aren't persisted
+	docs[1].Title = "copy"
+	docs[1].Title = "draft"
+	_, err = repo.Save()
+	require.NoError(t, err)
+	require.Same(t, copied, repo.Entities[1])
+
+	docs, err = repo.FindAll(func(d *document) bool { return true })
+	require.NoError(t, err)
+
+	docs[1].Title = "draft"
+	require.NoError(t, repo.MarkDirty(docs[1]))
+
+	_, err = repo.Save()
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+
+	repo.Reset()
+
+	docs, err = repo.FindAll(func(d *document) bool { return true })
+	require.NoError(t, err)
+
+	docs[1].Title = "copy"
+	_, err = repo.Save()
+	require.NoError(t, err)
+	require.Equal(t, "copy", repo.Entities[1].Title)
+	require.Equal(t, 2, repo.Entities[1].Version)
+	require.Same(t, draft, repo.Entities[0])
+
+	require.ErrorIs(t, repo.MarkDirty(draft), inmem.ErrNotFound)
+}
+
+// Test that read-only finds return copies of entities without adding them to
+// the transaction
+func TestRepositoryFindReadOnly(t *testing.T) {
+	repo := newDocumentRepository(t)
+	repo.Entities = []*document{{ID: "d1", Title: "draft", Version: 1}}
+
+	doc, err := repo.FindOneReadOnly(func(d *document) bool { return d.ID == "d1" })
+	require.NoError(t, err)
+	require.Empty(t, repo.Transaction)
+
+	doc.Title = "final"
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+	require.Equal(t, "draft", repo.Entities[0].Title)
+
+	_, err = repo.FindOneReadOnly(func(d *document) bool { return d.ID == "d2" })
+	require.ErrorIs(t, err, inmem.ErrNotFound)
+
+	// Uncommitted changes are visible
+	require.NoError(t, repo.Add(&document{ID: "d2", Title: "notes"}))
+
+	loaded, err := repo.FindOne(func(d *document) bool { return d.ID == "d1" })
+	require.NoError(t, err)
+	loaded.Title = "review"
+
+	docs, err := repo.FindAllReadOnly(func(d *document) bool { return true })
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	require.Equal(t, "notes", docs[0].Title)
+	require.Equal(t, "review", docs[1].Title)
+	require.NotSame(t, loaded, docs[1])
+	require.Len(t, repo.Transaction, 2)
+}
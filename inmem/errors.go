@@ -1,6 +1,54 @@
 package inmem
 
-import "errors"
+import (
+	"fmt"
 
-var ErrNotFound = errors.New("entity not found")
-var ErrAlreadyExists = errors.New("entity already exists")
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+)
+
+var ErrNotFound = dorkyerrors.NewNotFound("entity_not_found", "entity not found")
+var ErrAlreadyExists = dorkyerrors.NewConflict("entity_already_exists", "entity already exists")
+
+// ErrConcurrencyConflict is returned when saving a Versioned entity that was
+// modified since it was loaded
+var ErrConcurrencyConflict = dorkyerrors.NewConflict("concurrency_conflict", "entity was modified concurrently")
+
+// ConstraintError is returned when an entity can't be added to or saved in a
+// repository because it conflicts with another entity. It matches
+// ErrAlreadyExists with errors.Is.
+type ConstraintError struct {
+	// Constraint is the name of the constraint violated: the name given to
+	// WithConstraint or WithUniqueIndex, "key" when the entities have the
+	// same key, or "default" for the constraintEqualFn of CreateRepository
+	Constraint string
+
+	// Identity identifies the conflicting entity. It is the entity's key in
+	// repositories created with NewRepository, and the value returned by the
+	// identityFn given WithIdentity in repositories created with
+	// CreateRepository. It is nil when the repository has no identityFn.
+	Identity any
+
+	// Entity is a copy of the conflicting entity
+	Entity any
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Identity == nil {
+		return fmt.Sprintf("entity already exists: violates constraint %q", e.Constraint)
+	}
+
+	return fmt.Sprintf(
+		"entity already exists: violates constraint %q with %v",
+		e.Constraint, e.Identity,
+	)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	err := ErrAlreadyExists.WithDetail("constraint", e.Constraint)
+
+	if e.Identity != nil {
+		err = err.WithDetail("identity", e.Identity)
+	}
+
+	return err
+}
@@ -0,0 +1,347 @@
+package inmem
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// Codec encodes and decodes the entities persisted by a FileRepository
+type Codec interface {
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+// JSONCodec persists entities as JSON
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (JSONCodec) Decode(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// GobCodec persists entities with encoding/gob
+type GobCodec struct{}
+
+func (GobCodec) Encode(w io.Writer, v any) error {
+	return gob.NewEncoder(w).Encode(v)
+}
+
+func (GobCodec) Decode(r io.Reader, v any) error {
+	return gob.NewDecoder(r).Decode(v)
+}
+
+// FileRepository is a Repository whose persisted entities are stored in a
+// file, so that they outlive the process. Entities are found, added and
+// saved like those of the Repository it wraps, and a FileRepository can be
+// part of a UnitOfWork.
+//
+// Each save replaces the file atomically: the entities are written to a
+// temporary file that is renamed over the file once they have been written.
+// When part of a UnitOfWork, the temporary file is written as the
+// FileRepository is prepared, and renamed as it is committed. If the file
+// can't be replaced as it is committed, the entities are only persisted in
+// memory and the file is stale until the next save rewrites it.
+type FileRepository[Entity entity[Entity]] struct {
+	*Repository[Entity]
+
+	path  string
+	codec Codec
+
+	// file is shared with the copies of the repository made for UnitOfWork
+	// Runs
+	file *fileState
+
+	// pending is the temporary file written by Prepare, and prepared holds
+	// the entities of the Transaction it was written for
+	pending  string
+	prepared []Entity
+}
+
+// NewFileRepository creates a FileRepository that stores the persisted
+// entities of repo in the file at path, encoded with codec. The entities in
+// the file, if it exists, are loaded into repo.
+func NewFileRepository[Entity entity[Entity]](
+	path string,
+	codec Codec,
+	repo *Repository[Entity],
+) (
+	*FileRepository[Entity],
+	error,
+) {
+	if path == "" || codec == nil || repo == nil {
+		return nil, dorkyerrors.NewInvalid(
+			"invalid_repository", "path, codec and repo are required",
+		)
+	}
+
+	fileRepo := &FileRepository[Entity]{
+		Repository: repo,
+		path:       path,
+		codec:      codec,
+		file:       &fileState{},
+	}
+
+	if err := fileRepo.load(); err != nil {
+		return nil, err
+	}
+
+	return fileRepo, nil
+}
+
+func (repo *FileRepository[Entity]) load() error {
+	f, err := os.Open(repo.path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return loadError(repo.path, err)
+	}
+
+	defer f.Close()
+
+	var entities []Entity
+
+	if err := repo.codec.Decode(f, &entities); err != nil {
+		return loadError(repo.path, err)
+	}
+
+	repo.mu.Lock()
+	repo.Entities = entities
+	repo.mu.Unlock()
+
+	return nil
+}
+
+// fileState records whether a repository's file holds its persisted entities
+type fileState struct {
+	// stale is set when Commit couldn't replace the file, until a save
+	// replaces it
+	stale bool
+}
+
+// Save persists the entities in the repository's Transaction collection like
+// Repository.Save, replacing the repository's file with one holding the
+// entities persisted. Nothing is persisted if the file can't be written.
+func (repo *FileRepository[Entity]) Save() ([]messages.Event, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if err := repo.prepareFile(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(repo.pending, repo.path); err != nil {
+		repo.discardPending()
+		return nil, writeError(repo.path, err)
+	}
+
+	repo.file.stale = false
+
+	return repo.commitFile(), nil
+}
+
+// Prepare validates the entities in the repository's Transaction collection
+// like Repository.Prepare, and writes the entities that will be persisted
+// once they are committed to a temporary file
+func (repo *FileRepository[Entity]) Prepare() error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.prepareLocked()
+}
+
+func (repo *FileRepository[Entity]) prepareLocked() error {
+	return repo.prepareFile()
+}
+
+// Commit persists the entities validated by Prepare and renames the
+// temporary file written by Prepare over the repository's file. Commit can't
+// fail, so if the file can't be replaced, or Prepare wasn't called, the
+// entities are only persisted in memory and the file is stale until the next
+// Save or Prepare and Commit rewrite it with all of the persisted entities.
+func (repo *FileRepository[Entity]) Commit() []messages.Event {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.commitLocked()
+}
+
+func (repo *FileRepository[Entity]) commitLocked() []messages.Event {
+	if repo.pending == "" {
+		repo.file.stale = true
+		return repo.commit(repo.changedEntities())
+	}
+
+	if err := os.Rename(repo.pending, repo.path); err != nil {
+		os.Remove(repo.pending)
+		repo.file.stale = true
+	} else {
+		repo.file.stale = false
+	}
+
+	return repo.commitFile()
+}
+
+// Stale reports whether the repository's file is missing entities persisted
+// by a Commit that couldn't replace it
+func (repo *FileRepository[Entity]) Stale() bool {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return repo.file.stale
+}
+
+// Reset clears the repository's Transaction collection like
+// Repository.Reset, and removes the temporary file written by Prepare
+func (repo *FileRepository[Entity]) Reset() {
+	repo.mu.Lock()
+	repo.discardPending()
+	repo.mu.Unlock()
+
+	repo.Repository.Reset()
+}
+
+// transaction returns a copy of the repository with a Transaction of its own
+// like Repository.transaction, which stores its entities in the same file
+func (repo *FileRepository[Entity]) transaction() repo {
+	return &FileRepository[Entity]{
+		Repository: repo.Repository.transaction().(*Repository[Entity]),
+		path:       repo.path,
+		codec:      repo.codec,
+		file:       repo.file,
+	}
+}
+
+// prepareFile validates the Transaction and writes the entities that result
+// from committing it to a temporary file
+func (repo *FileRepository[Entity]) prepareFile() error {
+	repo.discardPending()
+
+	changed, err := repo.prepare()
+
+	if err != nil {
+		return err
+	}
+
+	pending, err := repo.writeTemp(repo.preview(changed))
+
+	if err != nil {
+		return writeError(repo.path, err)
+	}
+
+	repo.pending = pending
+	repo.prepared = changed
+
+	return nil
+}
+
+// commitFile commits the entities prepared once the temporary file has been
+// renamed
+func (repo *FileRepository[Entity]) commitFile() []messages.Event {
+	changed := repo.prepared
+
+	repo.pending = ""
+	repo.prepared = nil
+
+	return repo.commit(changed)
+}
+
+func (repo *FileRepository[Entity]) discardPending() {
+	if repo.pending != "" {
+		os.Remove(repo.pending)
+	}
+
+	repo.pending = ""
+	repo.prepared = nil
+}
+
+// writeTemp writes the entities to a temporary file in the directory of the
+// repository's file, so that it can be renamed over it, and returns its path
+func (repo *FileRepository[Entity]) writeTemp(entities []Entity) (string, error) {
+	dir, name := filepath.Split(repo.path)
+
+	f, err := os.CreateTemp(dir, "."+name+".*.tmp")
+
+	if err != nil {
+		return "", err
+	}
+
+	err = repo.codec.Encode(f, entities)
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// preview returns the persisted entities as they will be once the changed
+// entities are committed, without changing the repository
+func (repo *Repository[Entity]) preview(changed []Entity) []Entity {
+	replaced := make(map[int]Entity)
+
+	var added []Entity
+
+	for _, toSave := range changed {
+		committed := toSave.Clone()
+		committed.ResetEvents()
+
+		if versioned, ok := any(committed).(Versioned); ok {
+			versioned.SetVersion(versioned.GetVersion() + 1)
+		}
+
+		if position, ok := repo.position(toSave); ok && !repo.isRemoved(toSave) {
+			replaced[position] = committed
+		} else {
+			added = append(added, committed)
+		}
+	}
+
+	persisted := repo.store().Entities
+
+	entities := make([]Entity, 0, len(persisted)+len(added))
+
+	for position, entity := range persisted {
+		if committed, ok := replaced[position]; ok {
+			entities = append(entities, committed)
+		} else if !repo.isRemoved(entity) {
+			entities = append(entities, entity)
+		}
+	}
+
+	return append(entities, added...)
+}
+
+func loadError(path string, err error) error {
+	return dorkyerrors.Wrap(
+		err, dorkyerrors.Internal, "repository_load_failed", "cannot load repository",
+	).WithDetail("path", path)
+}
+
+func writeError(path string, err error) error {
+	return dorkyerrors.Wrap(
+		err, dorkyerrors.Unavailable, "repository_write_failed", "cannot write repository",
+	).WithDetail("path", path)
+}
@@ -0,0 +1,217 @@
+package inmem_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/inmem"
+)
+
+func newFileDocumentRepository(
+	t *testing.T,
+	path string,
+	codec inmem.Codec,
+) *inmem.FileRepository[*document] {
+	repo, err := inmem.NewFileRepository(path, codec, newDocumentRepository(t))
+	require.NoError(t, err)
+	return repo
+}
+
+// requireFiles verifies the names of the files in dir, to ensure that no
+// temporary files are left behind
+func requireFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var found []string
+
+	for _, entry := range entries {
+		found = append(found, entry.Name())
+	}
+
+	require.Equal(t, names, found)
+}
+
+// Test that saved entities are loaded by repositories using the same file
+func TestFileRepository(t *testing.T) {
+	for name, codec := range map[string]inmem.Codec{
+		"json": inmem.JSONCodec{},
+		"gob":  inmem.GobCodec{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "documents")
+
+			repo := newFileDocumentRepository(t, path, codec)
+			require.Empty(t, repo.Entities)
+
+			require.NoError(t, repo.Add(&document{ID: "d1", Title: "draft"}))
+			require.NoError(t, repo.Add(&document{ID: "d2", Title: "notes"}))
+
+			_, err := repo.Save()
+			require.NoError(t, err)
+			requireFiles(t, dir, "documents")
+
+			doc, err := repo.FindOne(func(d *document) bool { return d.ID == "d1" })
+			require.NoError(t, err)
+			doc.Title = "final"
+
+			notes, err := repo.FindOne(func(d *document) bool { return d.ID == "d2" })
+			require.NoError(t, err)
+			require.NoError(t, repo.Remove(notes))
+
+			// Changes that are reset aren't written
+			require.NoError(t, repo.Add(&document{ID: "d3", Title: "scratch"}))
+			require.NoError(t, repo.Prepare())
+			repo.Reset()
+			requireFiles(t, dir, "documents")
+
+			doc, err = repo.FindOne(func(d *document) bool { return d.ID == "d1" })
+			require.NoError(t, err)
+			doc.Title = "final"
+
+			_, err = repo.Save()
+			require.NoError(t, err)
+
+			loaded := newFileDocumentRepository(t, path, codec)
+			require.Len(t, loaded.Entities, 2)
+			require.Equal(t, "final", loaded.Entities[0].Title)
+			require.Equal(t, 2, loaded.Entities[0].Version)
+			require.Equal(t, "notes", loaded.Entities[1].Title)
+
+			// Entities that violate constraints aren't written
+			added := &document{ID: "d3", Title: "scratch"}
+			require.NoError(t, loaded.Add(added))
+			added.Title = "final"
+
+			_, err = loaded.Save()
+			require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+			requireFiles(t, dir, "documents")
+
+			loaded = newFileDocumentRepository(t, path, codec)
+			require.Len(t, loaded.Entities, 2)
+		})
+	}
+}
+
+type documentFileRepos struct {
+	Documents *inmem.FileRepository[*document]
+	Users     *inmem.Repository[*user]
+}
+
+// Test that file repositories are written when a UnitOfWork commits
+func TestFileRepositoryUnitOfWork(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "documents.json")
+
+	users, err := inmem.CreateRepository(
+		func(a, b *user) bool { return a.ID == b.ID },
+		func(a, b *user) bool { return a.Email == b.Email },
+	)
+	require.NoError(t, err)
+	users.Entities = []*user{{ID: "u1", Email: "ada@example.com"}}
+
+	repos := documentFileRepos{
+		Documents: newFileDocumentRepository(t, path, inmem.JSONCodec{}),
+		Users:     &users,
+	}
+
+	uow := inmem.NewUnitOfWork(repos, repos.Documents, repos.Users)
+
+	_, err = uow.Run(context.Background(), func(repos documentFileRepos) error {
+		return repos.Documents.Add(&document{ID: "d1", Title: "draft"})
+	})
+	require.NoError(t, err)
+
+	// Nothing is written when another repo can't be committed
+	_, err = uow.Run(context.Background(), func(repos documentFileRepos) error {
+		if err := repos.Documents.Add(&document{ID: "d2", Title: "notes"}); err != nil {
+			return err
+		}
+
+		u := &user{ID: "u2", Email: "bob@example.com"}
+		if err := repos.Users.Add(u); err != nil {
+			return err
+		}
+
+		// The conflict is detected once the documents have been prepared
+		u.Email = "ada@example.com"
+
+		return nil
+	})
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+	requireFiles(t, dir, "documents.json")
+
+	loaded := newFileDocumentRepository(t, path, inmem.JSONCodec{})
+	require.Len(t, loaded.Entities, 1)
+	require.Equal(t, "draft", loaded.Entities[0].Title)
+}
+
+type failingCodec struct {
+	inmem.JSONCodec
+}
+
+func (failingCodec) Encode(w io.Writer, v any) error {
+	return errors.New("disk full")
+}
+
+// Test that entities aren't persisted when the file can't be written
+func TestFileRepositoryWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "documents")
+
+	repo := newFileDocumentRepository(t, path, failingCodec{})
+	require.NoError(t, repo.Add(&document{ID: "d1", Title: "draft"}))
+
+	_, err := repo.Save()
+	require.Equal(t, "repository_write_failed", dorkyerrors.CodeOf(err))
+	require.ErrorContains(t, err, "disk full")
+	require.Empty(t, repo.Entities)
+	requireFiles(t, dir)
+
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+
+	_, err = inmem.NewFileRepository(path, inmem.JSONCodec{}, newDocumentRepository(t))
+	require.Equal(t, "repository_load_failed", dorkyerrors.CodeOf(err))
+}
+
+// Test that a file Commit fails to write is left stale without failing the
+// next Save, which rewrites it with all of the persisted entities
+func TestFileRepositoryCommitFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "documents.json")
+
+	repo := newFileDocumentRepository(t, path, inmem.JSONCodec{})
+	require.NoError(t, repo.Add(&document{ID: "d1", Title: "draft"}))
+	require.NoError(t, repo.Prepare())
+
+	// A directory in place of the file keeps the temporary file from being
+	// renamed over it, which a read-only directory doesn't for privileged
+	// users
+	require.NoError(t, os.Mkdir(path, 0o700))
+
+	events := repo.Commit()
+	require.Empty(t, events)
+	require.Len(t, repo.Entities, 1)
+	require.True(t, repo.Stale())
+	requireFiles(t, dir, "documents.json")
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, repo.Add(&document{ID: "d2", Title: "notes"}))
+
+	_, err := repo.Save()
+	require.NoError(t, err)
+	require.False(t, repo.Stale())
+
+	loaded := newFileDocumentRepository(t, path, inmem.JSONCodec{})
+	require.Len(t, loaded.Entities, 2)
+}
@@ -0,0 +1,418 @@
+package inmem
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+)
+
+// RepositoryOption configures a Repository as it is created
+type RepositoryOption[Entity entity[Entity]] func(*repositoryConfig[Entity])
+
+type repositoryConfig[Entity entity[Entity]] struct {
+	indexes     []*index[Entity]
+	constraints []constraint[Entity]
+	identityFn  func(Entity) any
+	softDelete  bool
+}
+
+// WithIndex adds a secondary index named name to the repository, indexing
+// entities by the value returned by keyFn. Entities are looked up through the
+// index with FindByIndex.
+func WithIndex[Entity entity[Entity], K comparable](
+	name string,
+	keyFn func(Entity) K,
+) RepositoryOption[Entity] {
+	return withIndex(name, keyFn, false)
+}
+
+// WithUniqueIndex adds a secondary index like WithIndex, and requires that no
+// two entities have the same value for the index. Adding or saving an entity
+// that duplicates another's value returns ErrAlreadyExists, so unique indexes
+// can be used in place of a constraintEqualFn. The constraint violated is
+// named after the index.
+func WithUniqueIndex[Entity entity[Entity], K comparable](
+	name string,
+	keyFn func(Entity) K,
+) RepositoryOption[Entity] {
+	return withIndex(name, keyFn, true)
+}
+
+func withIndex[Entity entity[Entity], K comparable](
+	name string,
+	keyFn func(Entity) K,
+	unique bool,
+) RepositoryOption[Entity] {
+	return func(config *repositoryConfig[Entity]) {
+		idx := &index[Entity]{name: name, unique: unique, valueType: reflect.TypeFor[K]()}
+
+		if keyFn != nil {
+			idx.keyFn = func(entity Entity) any { return keyFn(entity) }
+		}
+
+		config.indexes = append(config.indexes, idx)
+	}
+}
+
+// NewRepository creates a Repository that identifies entities by the key
+// returned by keyFn, so that entities are found by their key and saved
+// without comparing them to every persisted entity. Uniqueness constraints
+// are declared with WithUniqueIndex.
+func NewRepository[Entity entity[Entity], K comparable](
+	keyFn func(Entity) K,
+	opts ...RepositoryOption[Entity],
+) (
+	Repository[Entity],
+	error,
+) {
+	if keyFn == nil {
+		return Repository[Entity]{}, dorkyerrors.NewInvalid(
+			"invalid_repository", "keyFn cannot be nil",
+		)
+	}
+
+	return newRepository(
+		func(entity Entity) any { return keyFn(entity) },
+		reflect.TypeFor[K](),
+		nil,
+		nil,
+		opts,
+	)
+}
+
+// index maps the values of an index, which are of type valueType, to the
+// set of positions of the persisted entities with that value
+type index[Entity any] struct {
+	name      string
+	unique    bool
+	keyFn     func(Entity) any
+	valueType reflect.Type
+	positions map[any]map[int]struct{}
+}
+
+func (idx *index[Entity]) add(entity Entity, position int) {
+	value := idx.keyFn(entity)
+
+	if idx.positions[value] == nil {
+		idx.positions[value] = make(map[int]struct{})
+	}
+
+	idx.positions[value][position] = struct{}{}
+}
+
+func (idx *index[Entity]) remove(entity Entity, position int) {
+	value := idx.keyFn(entity)
+
+	delete(idx.positions[value], position)
+
+	if len(idx.positions[value]) == 0 {
+		delete(idx.positions, value)
+	}
+}
+
+// validate verifies the indexes and constraints configured by the
+// repository's options
+func (config *repositoryConfig[Entity]) validate() error {
+	names := make(map[string]bool)
+
+	for _, c := range config.constraints {
+		if c.equalFn == nil {
+			return dorkyerrors.NewInvalid(
+				"invalid_repository",
+				fmt.Sprintf("equalFn of constraint %q cannot be nil", c.name),
+			)
+		}
+
+		if names[c.name] {
+			return dorkyerrors.NewInvalid(
+				"invalid_repository",
+				fmt.Sprintf("constraint %q is defined more than once", c.name),
+			)
+		}
+
+		names[c.name] = true
+	}
+
+	indexNames := make(map[string]bool)
+
+	for _, idx := range config.indexes {
+		if idx.keyFn == nil {
+			return dorkyerrors.NewInvalid(
+				"invalid_repository",
+				fmt.Sprintf("keyFn of index %q cannot be nil", idx.name),
+			)
+		}
+
+		if indexNames[idx.name] {
+			return dorkyerrors.NewInvalid(
+				"invalid_repository",
+				fmt.Sprintf("index %q is defined more than once", idx.name),
+			)
+		}
+
+		if idx.unique && names[idx.name] {
+			return dorkyerrors.NewInvalid(
+				"invalid_repository",
+				fmt.Sprintf("constraint %q is defined more than once", idx.name),
+			)
+		}
+
+		indexNames[idx.name] = true
+	}
+
+	return nil
+}
+
+// ensureIndexes rebuilds the indexes of the persisted entities if Entities
+// was replaced or extended without going through the repository
+func (repo *Repository[Entity]) ensureIndexes() {
+	if repo.keyFn == nil && len(repo.indexes) == 0 {
+		return
+	}
+
+	store := repo.store()
+
+	if store.indexed != nil && len(store.Entities) == store.indexedLen &&
+		(len(store.Entities) == 0 || &store.Entities[0] == store.indexed) {
+		return
+	}
+
+	repo.rebuildIndexes()
+}
+
+func (repo *Repository[Entity]) rebuildIndexes() {
+	store := repo.store()
+
+	if repo.keyFn != nil {
+		store.keys = make(map[any]int, len(store.Entities))
+	}
+
+	for _, idx := range repo.indexes {
+		idx.positions = make(map[any]map[int]struct{})
+	}
+
+	for position, entity := range store.Entities {
+		repo.indexEntity(entity, position)
+	}
+
+	repo.indexedEntities()
+}
+
+// indexedEntities records the Entities that are indexed
+func (repo *Repository[Entity]) indexedEntities() {
+	store := repo.store()
+
+	store.indexedLen = len(store.Entities)
+	store.indexed = new(Entity)
+
+	if len(store.Entities) > 0 {
+		store.indexed = &store.Entities[0]
+	}
+}
+
+func (repo *Repository[Entity]) indexEntity(entity Entity, position int) {
+	store := repo.store()
+
+	if repo.keyFn != nil {
+		store.keys[repo.keyFn(entity)] = position
+	}
+
+	for _, idx := range repo.indexes {
+		idx.add(entity, position)
+	}
+}
+
+func (repo *Repository[Entity]) unindexEntity(entity Entity, position int) {
+	store := repo.store()
+
+	if repo.keyFn != nil {
+		delete(store.keys, repo.keyFn(entity))
+	}
+
+	for _, idx := range repo.indexes {
+		idx.remove(entity, position)
+	}
+}
+
+// position returns the position of the persisted entity with the same
+// identity as the entity provided
+func (repo *Repository[Entity]) position(entity Entity) (int, bool) {
+	store := repo.store()
+
+	if repo.keyFn == nil {
+		for position, persisted := range store.Entities {
+			if repo.identityEqualFn(persisted, entity) {
+				return position, true
+			}
+		}
+		return 0, false
+	}
+
+	return repo.positionOfKey(repo.keyFn(entity))
+}
+
+func (repo *Repository[Entity]) positionOfKey(key any) (int, bool) {
+	store := repo.store()
+	repo.ensureIndexes()
+
+	position, ok := store.keys[key]
+
+	// Entities may have been replaced in place without going through the
+	// repository
+	if ok && (position >= len(store.Entities) || repo.keyFn(store.Entities[position]) != key) {
+		repo.rebuildIndexes()
+		position, ok = store.keys[key]
+	}
+
+	return position, ok
+}
+
+// positionsOfValue returns the positions of the persisted entities with the
+// value provided for the index, in the order the entities are stored
+func (repo *Repository[Entity]) positionsOfValue(idx *index[Entity], value any) []int {
+	store := repo.store()
+	repo.ensureIndexes()
+
+	positions := slices.Sorted(maps.Keys(idx.positions[value]))
+
+	for _, position := range positions {
+		if position >= len(store.Entities) || idx.keyFn(store.Entities[position]) != value {
+			repo.rebuildIndexes()
+			return slices.Sorted(maps.Keys(idx.positions[value]))
+		}
+	}
+
+	return positions
+}
+
+func (repo *Repository[Entity]) index(name string) (*index[Entity], error) {
+	for _, idx := range repo.indexes {
+		if idx.name == name {
+			return idx, nil
+		}
+	}
+
+	return nil, dorkyerrors.NewInvalid(
+		"unknown_index", fmt.Sprintf("repository has no index %q", name),
+	).WithDetail("index", name)
+}
+
+// FindByKey looks up the entity with the key provided in a repository created
+// with NewRepository. Like FindOne, the entity returned is added to the
+// repository's Transaction collection. The key must be of the type returned
+// by the repository's keyFn.
+func (repo *Repository[Entity]) FindByKey(key any) (Entity, error) {
+	var zero Entity
+
+	if err := repo.checkDirectUse(); err != nil {
+		return zero, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.keyFn == nil {
+		return zero, dorkyerrors.NewInvalid(
+			"no_repository_key", "repository doesn't identify entities by key",
+		)
+	}
+
+	if err := checkValueType("invalid_key", "key", repo.keyType, key); err != nil {
+		return zero, err
+	}
+
+	if entity, ok := repo.transactionEntityByKey(key); ok {
+		if !repo.visible(entity) {
+			return zero, ErrNotFound
+		}
+		return entity, nil
+	}
+
+	position, ok := repo.positionOfKey(key)
+
+	if !ok || !repo.visiblePersisted(repo.store().Entities[position]) {
+		return zero, ErrNotFound
+	}
+
+	return repo.enlist(repo.store().Entities[position]), nil
+}
+
+// FindByIndex looks up the entities with the value provided for the named
+// index. Like FindAll, the entities returned are added to the repository's
+// Transaction collection. The value must be of the type returned by the
+// index's keyFn.
+func (repo *Repository[Entity]) FindByIndex(name string, value any) ([]Entity, error) {
+	if err := repo.checkDirectUse(); err != nil {
+		return nil, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	idx, err := repo.index(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	invalid := checkValueType("invalid_index_value", "index value", idx.valueType, value)
+
+	if invalid != nil {
+		return nil, invalid.WithDetail("index", name)
+	}
+
+	var all []Entity
+
+	for _, entity := range repo.Transaction {
+		if repo.visible(entity) && idx.keyFn(entity) == value {
+			all = append(all, entity)
+		}
+	}
+
+	for _, position := range repo.positionsOfValue(idx, value) {
+		entity := repo.store().Entities[position]
+
+		if !repo.visiblePersisted(entity) || repo.inTransaction(entity) {
+			continue
+		}
+
+		all = append(all, repo.enlist(entity))
+	}
+
+	return all, nil
+}
+
+// FindOneByIndex looks up an entity with the value provided for the named
+// index, which is usually a unique index. ErrNotFound is returned if there
+// is no such entity.
+func (repo *Repository[Entity]) FindOneByIndex(name string, value any) (Entity, error) {
+	all, err := repo.FindByIndex(name, value)
+
+	if err != nil {
+		var zero Entity
+		return zero, err
+	}
+
+	if len(all) == 0 {
+		var zero Entity
+		return zero, ErrNotFound
+	}
+
+	return all[0], nil
+}
+
+// checkValueType verifies that a key or index value looked up is of the type
+// of the keys or values stored, as values of other types are never equal to
+// them. Values of any type are accepted when the type stored is an interface.
+func checkValueType(code string, what string, valueType reflect.Type, value any) *dorkyerrors.Error {
+	if valueType.Kind() == reflect.Interface || reflect.TypeOf(value) == valueType {
+		return nil
+	}
+
+	return dorkyerrors.NewInvalid(
+		code, fmt.Sprintf("%s must be of type %v, not %T", what, valueType, value),
+	).WithDetail("type", valueType.String())
+}
@@ -0,0 +1,146 @@
+package inmem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/aggregate"
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/inmem"
+)
+
+type member struct {
+	aggregate.Aggregate
+	ID    int
+	Email string
+	Team  string
+}
+
+func (m *member) Clone() *member {
+	clone := *m
+	return &clone
+}
+
+func newMemberRepository(t *testing.T) *inmem.Repository[*member] {
+	repo, err := inmem.NewRepository(
+		func(m *member) int { return m.ID },
+		inmem.WithUniqueIndex("email", func(m *member) string { return m.Email }),
+		inmem.WithIndex("team", func(m *member) string { return m.Team }),
+	)
+	require.NoError(t, err)
+	return &repo
+}
+
+// Test looking entities up by key and through secondary indexes
+func TestRepositoryIndexes(t *testing.T) {
+	repo := newMemberRepository(t)
+
+	require.NoError(t, repo.Add(&member{ID: 1, Email: "ada@example.com", Team: "core"}))
+	require.NoError(t, repo.Add(&member{ID: 2, Email: "bob@example.com", Team: "core"}))
+	require.NoError(t, repo.Add(&member{ID: 3, Email: "cy@example.com", Team: "web"}))
+	_, err := repo.Save()
+	require.NoError(t, err)
+
+	m, err := repo.FindByKey(2)
+	require.NoError(t, err)
+	require.Equal(t, "bob@example.com", m.Email)
+
+	_, err = repo.FindByKey(4)
+	require.ErrorIs(t, err, inmem.ErrNotFound)
+
+	core, err := repo.FindByIndex("team", "core")
+	require.NoError(t, err)
+	require.Len(t, core, 2)
+
+	// Uncommitted changes are visible through the indexes
+	m.Team = "web"
+
+	web, err := repo.FindByIndex("team", "web")
+	require.NoError(t, err)
+	require.Len(t, web, 2)
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+
+	core, err = repo.FindByIndex("team", "core")
+	require.NoError(t, err)
+	require.Len(t, core, 1)
+	require.Equal(t, 1, core[0].ID)
+
+	m, err = repo.FindOneByIndex("email", "cy@example.com")
+	require.NoError(t, err)
+	require.Equal(t, 3, m.ID)
+
+	_, err = repo.FindByIndex("name", "cy")
+	require.Equal(t, "unknown_index", dorkyerrors.CodeOf(err))
+
+	// Keys and values of another type than the repository's are rejected
+	// rather than never being found
+	_, err = repo.FindByKey(int64(2))
+	require.Equal(t, "invalid_key", dorkyerrors.CodeOf(err))
+
+	_, err = repo.FindOneByIndex("email", []byte("cy@example.com"))
+	require.Equal(t, "invalid_index_value", dorkyerrors.CodeOf(err))
+
+	repo.Reset()
+
+	// Entities replaced without going through the repository are indexed
+	repo.Entities = []*member{{ID: 5, Email: "di@example.com", Team: "ops"}}
+
+	m, err = repo.FindByKey(5)
+	require.NoError(t, err)
+	require.Equal(t, "ops", m.Team)
+
+	_, err = repo.FindByKey(1)
+	require.ErrorIs(t, err, inmem.ErrNotFound)
+}
+
+// Test that keys and unique indexes are enforced
+func TestRepositoryUniqueIndexes(t *testing.T) {
+	repo := newMemberRepository(t)
+
+	require.NoError(t, repo.Add(&member{ID: 1, Email: "ada@example.com"}))
+	require.NoError(t, repo.Add(&member{ID: 2, Email: "bob@example.com"}))
+
+	err := repo.Add(&member{ID: 3, Email: "ada@example.com"})
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+
+	err = repo.Add(&member{ID: 1, Email: "cy@example.com"})
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+
+	err = repo.Add(&member{ID: 3, Email: "bob@example.com"})
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+
+	m, err := repo.FindByKey(1)
+	require.NoError(t, err)
+
+	m.Email = "bob@example.com"
+
+	_, err = repo.Save()
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+
+	m.Email = "ada@example.org"
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+
+	_, err = repo.FindOneByIndex("email", "ada@example.com")
+	require.ErrorIs(t, err, inmem.ErrNotFound)
+}
+
+// Test that invalid indexes are rejected
+func TestRepositoryInvalidIndexes(t *testing.T) {
+	_, err := inmem.NewRepository(
+		func(m *member) int { return m.ID },
+		inmem.WithIndex("team", func(m *member) string { return m.Team }),
+		inmem.WithIndex("team", func(m *member) string { return m.Email }),
+	)
+	require.Equal(t, "invalid_repository", dorkyerrors.CodeOf(err))
+
+	_, err = inmem.NewRepository[*member, int](nil)
+	require.Equal(t, "invalid_repository", dorkyerrors.CodeOf(err))
+}
@@ -0,0 +1,264 @@
+package inmem
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"iter"
+	"slices"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+)
+
+// Query describes the entities of a repository returned by FindPage
+type Query[Entity any] struct {
+	// Match selects the entities returned. All entities are matched when it
+	// is nil.
+	Match func(Entity) bool
+
+	// OrderBy compares entities like the comparators of slices.SortFunc to
+	// order the entities returned. Entities are returned in the order FindAll
+	// returns them when it is nil. OrderBy must order entities totally, such
+	// as by breaking ties by identity, for cursors to page through entities
+	// without skipping or repeating any.
+	OrderBy func(a, b Entity) int
+
+	// After continues a query from the Next cursor of the page preceding the
+	// one to be returned. It requires OrderBy.
+	After Cursor[Entity]
+
+	// Offset is the number of matched entities skipped, after any cursor
+	Offset int
+
+	// Limit is the maximum number of entities returned, or 0 for all of them
+	Limit int
+}
+
+// Cursor marks the position of a page of entities returned by FindPage, so
+// that the following page can be requested. The zero Cursor marks the start
+// of the entities.
+//
+// Cursors can be handed to clients and parsed from their requests: a Cursor
+// is encoded as text holding the last entity of its page encoded as JSON,
+// which OrderBy compares entities with, so OrderBy must only compare fields
+// that are encoded as JSON. The text is empty for the zero Cursor.
+type Cursor[Entity any] struct {
+	last  Entity
+	valid bool
+}
+
+// ParseCursor parses a cursor encoded by Cursor.String
+func ParseCursor[Entity any](text string) (Cursor[Entity], error) {
+	var c Cursor[Entity]
+	err := c.UnmarshalText([]byte(text))
+	return c, err
+}
+
+// IsZero determines if the cursor marks the start of the entities, which is
+// the case for the Next cursor of the last page
+func (c Cursor[Entity]) IsZero() bool {
+	return !c.valid
+}
+
+// String returns the cursor encoded as text, or an empty string if the
+// entity it holds can't be encoded
+func (c Cursor[Entity]) String() string {
+	text, _ := c.MarshalText()
+	return string(text)
+}
+
+// MarshalText encodes the cursor as text
+func (c Cursor[Entity]) MarshalText() ([]byte, error) {
+	if !c.valid {
+		return []byte{}, nil
+	}
+
+	data, err := json.Marshal(c.last)
+
+	if err != nil {
+		return nil, dorkyerrors.Wrap(
+			err, dorkyerrors.Internal, "invalid_cursor", "cannot encode cursor",
+		)
+	}
+
+	text := make([]byte, base64.RawURLEncoding.EncodedLen(len(data)))
+	base64.RawURLEncoding.Encode(text, data)
+
+	return text, nil
+}
+
+// UnmarshalText decodes a cursor encoded by MarshalText
+func (c *Cursor[Entity]) UnmarshalText(text []byte) error {
+	*c = Cursor[Entity]{}
+
+	if len(text) == 0 {
+		return nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(string(text))
+
+	var last Entity
+
+	if err == nil {
+		err = json.Unmarshal(data, &last)
+	}
+
+	if err == nil && string(data) == "null" {
+		err = errors.New("cursor holds no entity")
+	}
+
+	if err != nil {
+		return dorkyerrors.Wrap(
+			err, dorkyerrors.Invalid, "invalid_cursor", "cannot decode cursor",
+		)
+	}
+
+	*c = Cursor[Entity]{last: last, valid: true}
+
+	return nil
+}
+
+// Page is a page of entities returned by FindPage
+type Page[Entity any] struct {
+	Entities []Entity
+
+	// Next is the cursor of the following page. It is zero when there are no
+	// more entities.
+	Next Cursor[Entity]
+}
+
+// FindPage returns the page of entities described by the query provided.
+// Like FindAll, the entities returned are added to the repository's
+// Transaction collection, but only the entities within the page are added.
+func (repo *Repository[Entity]) FindPage(query Query[Entity]) (Page[Entity], error) {
+	if err := repo.checkDirectUse(); err != nil {
+		return Page[Entity]{}, err
+	}
+
+	if query.Offset < 0 || query.Limit < 0 {
+		return Page[Entity]{}, dorkyerrors.NewInvalid(
+			"invalid_query", "offset and limit cannot be negative",
+		)
+	}
+
+	if query.After.valid && query.OrderBy == nil {
+		return Page[Entity]{}, dorkyerrors.NewInvalid(
+			"invalid_query", "cursors require the query to be ordered",
+		)
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	type candidate struct {
+		entity    Entity
+		persisted bool
+	}
+
+	var candidates []candidate
+
+	for entity, persisted := range repo.matching(query.Match) {
+		candidates = append(candidates, candidate{entity, persisted})
+	}
+
+	if query.OrderBy != nil {
+		slices.SortStableFunc(candidates, func(a, b candidate) int {
+			return query.OrderBy(a.entity, b.entity)
+		})
+	}
+
+	if query.After.valid {
+		start := slices.IndexFunc(candidates, func(c candidate) bool {
+			return query.OrderBy(c.entity, query.After.last) > 0
+		})
+
+		if start < 0 {
+			start = len(candidates)
+		}
+
+		candidates = candidates[start:]
+	}
+
+	candidates = candidates[min(query.Offset, len(candidates)):]
+
+	var page Page[Entity]
+
+	if query.Limit > 0 && query.Limit < len(candidates) {
+		candidates = candidates[:query.Limit]
+		last := candidates[len(candidates)-1].entity
+
+		// The cursor holds a copy so that it isn't moved by changes made to
+		// the entities returned, without the events it raised so that it
+		// can be encoded
+		last = last.Clone()
+		last.ResetEvents()
+
+		page.Next = Cursor[Entity]{last: last, valid: true}
+	}
+
+	for _, c := range candidates {
+		if c.persisted {
+			page.Entities = append(page.Entities, repo.enlist(c.entity))
+		} else {
+			page.Entities = append(page.Entities, c.entity)
+		}
+	}
+
+	return page, nil
+}
+
+// Count returns the number of entities that match without adding them to
+// the repository's Transaction collection
+func (repo *Repository[Entity]) Count(matchFn func(Entity) bool) (int, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	count := 0
+
+	for range repo.matching(matchFn) {
+		count++
+	}
+
+	return count, nil
+}
+
+// Exists determines if any entity matches without adding it to the
+// repository's Transaction collection
+func (repo *Repository[Entity]) Exists(matchFn func(Entity) bool) (bool, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for range repo.matching(matchFn) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// matching yields the entities that can be found in the repository that
+// match, or all of them if matchFn is nil. Entities in the Transaction
+// collection are yielded first, and then the persisted entities that aren't
+// in it, which are yielded with true.
+func (repo *Repository[Entity]) matching(matchFn func(Entity) bool) iter.Seq2[Entity, bool] {
+	matches := func(entity Entity) bool {
+		return matchFn == nil || matchFn(entity)
+	}
+
+	return func(yield func(Entity, bool) bool) {
+		for _, entity := range repo.Transaction {
+			if repo.visible(entity) && matches(entity) && !yield(entity, false) {
+				return
+			}
+		}
+
+		for _, entity := range repo.store().Entities {
+			if !repo.visiblePersisted(entity) || repo.inTransaction(entity) || !matches(entity) {
+				continue
+			}
+
+			if !yield(entity, true) {
+				return
+			}
+		}
+	}
+}
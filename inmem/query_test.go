@@ -0,0 +1,168 @@
+package inmem_test
+
+import (
+	"cmp"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/inmem"
+)
+
+func memberIDs(members []*member) []int {
+	var ids []int
+
+	for _, m := range members {
+		ids = append(ids, m.ID)
+	}
+
+	return ids
+}
+
+// Test ordering and paging through entities with offsets and cursors
+func TestRepositoryFindPage(t *testing.T) {
+	repo := newMemberRepository(t)
+	repo.Entities = []*member{
+		{ID: 1, Email: "eve@example.com", Team: "core"},
+		{ID: 2, Email: "ada@example.com", Team: "web"},
+		{ID: 3, Email: "dan@example.com", Team: "core"},
+		{ID: 4, Email: "bob@example.com", Team: "core"},
+		{ID: 5, Email: "cy@example.com", Team: "core"},
+	}
+
+	byEmail := func(a, b *member) int { return cmp.Compare(a.Email, b.Email) }
+	core := func(m *member) bool { return m.Team == "core" }
+
+	page, err := repo.FindPage(inmem.Query[*member]{Match: core, OrderBy: byEmail, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int{4, 5}, memberIDs(page.Entities))
+	require.False(t, page.Next.IsZero())
+	require.Len(t, repo.Transaction, 2)
+
+	// Changes to the entities returned don't move the cursor
+	page.Entities[1].Email = "zed@example.com"
+
+	page, err = repo.FindPage(inmem.Query[*member]{
+		Match: core, OrderBy: byEmail, After: page.Next, Limit: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{3, 1}, memberIDs(page.Entities))
+	require.False(t, page.Next.IsZero())
+
+	page, err = repo.FindPage(inmem.Query[*member]{
+		Match: core, OrderBy: byEmail, After: page.Next, Limit: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{5}, memberIDs(page.Entities))
+	require.True(t, page.Next.IsZero())
+
+	page, err = repo.FindPage(inmem.Query[*member]{OrderBy: byEmail, Offset: 1, Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, []int{4, 3, 1}, memberIDs(page.Entities))
+
+	page, err = repo.FindPage(inmem.Query[*member]{Offset: 10})
+	require.NoError(t, err)
+	require.Empty(t, page.Entities)
+
+	_, err = repo.FindPage(inmem.Query[*member]{Limit: -1})
+	require.Equal(t, "invalid_query", dorkyerrors.CodeOf(err))
+
+	page, err = repo.FindPage(inmem.Query[*member]{OrderBy: byEmail, Limit: 1})
+	require.NoError(t, err)
+
+	_, err = repo.FindPage(inmem.Query[*member]{After: page.Next})
+	require.Equal(t, "invalid_query", dorkyerrors.CodeOf(err))
+}
+
+// Test that cursors are encoded as text that pages can be continued from
+func TestRepositoryFindPageEncodedCursor(t *testing.T) {
+	repo := newMemberRepository(t)
+	repo.Entities = []*member{
+		{ID: 1, Email: "cy@example.com"},
+		{ID: 2, Email: "ada@example.com"},
+		{ID: 3, Email: "bob@example.com"},
+	}
+
+	byEmail := func(a, b *member) int { return cmp.Compare(a.Email, b.Email) }
+
+	page, err := repo.FindPage(inmem.Query[*member]{OrderBy: byEmail, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, []int{2}, memberIDs(page.Entities))
+
+	text := page.Next.String()
+	require.NotEmpty(t, text)
+
+	after, err := inmem.ParseCursor[*member](text)
+	require.NoError(t, err)
+
+	page, err = repo.FindPage(inmem.Query[*member]{OrderBy: byEmail, After: after, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, []int{3}, memberIDs(page.Entities))
+
+	// Cursors are encoded as JSON strings
+	data, err := json.Marshal(page)
+	require.NoError(t, err)
+
+	var decoded inmem.Page[*member]
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	page, err = repo.FindPage(inmem.Query[*member]{OrderBy: byEmail, After: decoded.Next, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, memberIDs(page.Entities))
+	require.True(t, page.Next.IsZero())
+	require.Empty(t, page.Next.String())
+
+	after, err = inmem.ParseCursor[*member]("")
+	require.NoError(t, err)
+	require.True(t, after.IsZero())
+
+	_, err = inmem.ParseCursor[*member]("not a cursor")
+	require.Equal(t, "invalid_cursor", dorkyerrors.CodeOf(err))
+
+	_, err = inmem.ParseCursor[*member]("bnVsbA")
+	require.Equal(t, "invalid_cursor", dorkyerrors.CodeOf(err))
+}
+
+// Test that counting entities doesn't add them to the transaction
+func TestRepositoryCountAndExists(t *testing.T) {
+	repo := newMemberRepository(t)
+	repo.Entities = []*member{
+		{ID: 1, Email: "ada@example.com", Team: "core"},
+		{ID: 2, Email: "bob@example.com", Team: "core"},
+		{ID: 3, Email: "cy@example.com", Team: "web"},
+	}
+
+	core := func(m *member) bool { return m.Team == "core" }
+
+	count, err := repo.Count(core)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	exists, err := repo.Exists(func(m *member) bool { return m.Team == "ops" })
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Empty(t, repo.Transaction)
+
+	// Uncommitted changes are counted
+	m, err := repo.FindByKey(3)
+	require.NoError(t, err)
+	m.Team = "core"
+
+	require.NoError(t, repo.Remove(&member{ID: 1}))
+	require.NoError(t, repo.Add(&member{ID: 4, Email: "dan@example.com", Team: "ops"}))
+
+	count, err = repo.Count(core)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	count, err = repo.Count(nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	exists, err = repo.Exists(func(m *member) bool { return m.Team == "ops" })
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Len(t, repo.Transaction, 2)
+}
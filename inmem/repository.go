@@ -1,9 +1,12 @@
 package inmem
 
 import (
-	"errors"
+	"reflect"
 	"slices"
+	"sync"
+	"sync/atomic"
 
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
 	"github.com/dmpettyp/dorky/messages"
 )
 
@@ -18,11 +21,27 @@ type entity[T any] interface {
 	Clone() T
 }
 
+// Versioned may be implemented by entities to have the version of the entity
+// tracked by a Repository. A Versioned entity can only be saved if its version
+// is the version of the persisted entity it replaces, which is the case unless
+// the entity was saved since it was loaded. Saving an entity increments its
+// version.
+type Versioned interface {
+	GetVersion() int
+	SetVersion(version int)
+}
+
 // Repository defines a generic in-memory repository that can be used as a base
 // for in-memory repositories for concrete entities.
 //
 // Entities stored in the repository must implement the generic entity
 // interface
+//
+// A Repository is safe for concurrent use. Each of its methods is atomic. The
+// Transaction collection is shared by the callers of its methods, but each
+// UnitOfWork Run uses the repository through a copy with a Transaction of its
+// own, which shares the repository's persisted entities. The repository can't
+// be used directly while such a Run is in progress.
 type Repository[Entity entity[Entity]] struct {
 	// Entities is the collection of "persisted". This is the durable backing for
 	// the inmen repository.
@@ -32,47 +51,386 @@ type Repository[Entity entity[Entity]] struct {
 	// These entities may be modified and will be persisted on Save() or discarded on Reset()
 	Transaction []Entity
 
-	identityEqualFn   func(Entity, Entity) bool
-	constraintEqualFn func(Entity, Entity) bool
+	// transactionKeys holds the entities in Transaction by their identity
+	// keys, and keyed and keyedLen record the Transaction it holds so that it
+	// is rebuilt if Transaction is replaced
+	transactionKeys map[any]Entity
+	keyed           *Entity
+	keyedLen        int
+
+	// removed contains the entities removed during this UoW transaction,
+	// which will be deleted on Save() unless the repository soft deletes
+	removed    entitySet[Entity]
+	softDelete bool
+
+	// dirty contains the entities in the Transaction marked with MarkDirty
+	dirty entitySet[Entity]
+
+	// loaded contains the persisted entities that the entities in the
+	// Transaction were copied from, as they were when they were copied
+	loaded entitySet[Entity]
+
+	identityEqualFn func(Entity, Entity) bool
+	constraints     []constraint[Entity]
+
+	// identityFn identifies the entities of repositories created with
+	// CreateRepository, when given WithIdentity
+	identityFn func(Entity) any
+
+	// keyFn identifies entities in repositories created with NewRepository
+	// by keys of type keyType, and keys holds the position of each persisted
+	// entity by its key
+	keyFn   func(Entity) any
+	keyType reflect.Type
+	keys    map[any]int
+
+	indexes []*index[Entity]
+
+	// indexed and indexedLen record the Entities that are indexed, so that
+	// the indexes are rebuilt if Entities is replaced
+	indexed    *Entity
+	indexedLen int
+
+	// base is the repository whose persisted entities are used by the copy
+	// of it made for a UnitOfWork Run, and is nil for other repositories
+	base *Repository[Entity]
+
+	// mu guards Entities and Transaction. It is a pointer so that the copies
+	// of the Repository made for UnitOfWork Runs share it.
+	mu *sync.RWMutex
+
+	// runs counts the UnitOfWork Runs in progress that use copies of the
+	// repository, during which the repository can't be used directly
+	runs *atomic.Int64
 }
 
+// CreateRepository creates a Repository that identifies entities with
+// identityEqualFn. constraintEqualFn, which may be nil, defines the
+// repository's "default" uniqueness constraint. Further constraints are added
+// with WithConstraint.
 func CreateRepository[Entity entity[Entity]](
 	identityEqualFn func(Entity, Entity) bool,
 	constraintEqualFn func(Entity, Entity) bool,
+	opts ...RepositoryOption[Entity],
 ) (
 	Repository[Entity],
 	error,
 ) {
 	if identityEqualFn == nil {
-		return Repository[Entity]{}, errors.New("identityEqualFn cannot be nil")
+		return Repository[Entity]{}, dorkyerrors.NewInvalid(
+			"invalid_repository", "identityEqualFn cannot be nil",
+		)
+	}
+
+	return newRepository(nil, nil, identityEqualFn, constraintEqualFn, opts)
+}
+
+func newRepository[Entity entity[Entity]](
+	keyFn func(Entity) any,
+	keyType reflect.Type,
+	identityEqualFn func(Entity, Entity) bool,
+	constraintEqualFn func(Entity, Entity) bool,
+	opts []RepositoryOption[Entity],
+) (
+	Repository[Entity],
+	error,
+) {
+	var config repositoryConfig[Entity]
+
+	if constraintEqualFn != nil {
+		config.constraints = append(config.constraints, constraint[Entity]{
+			name: defaultConstraint, equalFn: constraintEqualFn,
+		})
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if err := config.validate(); err != nil {
+		return Repository[Entity]{}, err
 	}
-	if constraintEqualFn == nil {
-		return Repository[Entity]{}, errors.New("constraintEqualFn cannot be nil")
+
+	if config.softDelete {
+		var zero Entity
+
+		if _, ok := any(zero).(SoftDeletable); !ok {
+			return Repository[Entity]{}, dorkyerrors.NewInvalid(
+				"invalid_repository",
+				"entities must implement SoftDeletable to be soft deleted",
+			)
+		}
 	}
 
 	return Repository[Entity]{
-		Entities:          nil,
-		Transaction:       nil,
-		identityEqualFn:   identityEqualFn,
-		constraintEqualFn: constraintEqualFn,
+		Entities:        nil,
+		Transaction:     nil,
+		identityEqualFn: identityEqualFn,
+		constraints:     config.constraints,
+		identityFn:      config.identityFn,
+		keyFn:           keyFn,
+		keyType:         keyType,
+		indexes:         config.indexes,
+		softDelete:      config.softDelete,
+		mu:              &sync.RWMutex{},
+		runs:            &atomic.Int64{},
 	}, nil
 }
 
+// store returns the repository holding the persisted entities, which is the
+// repository itself unless it is the copy made for a UnitOfWork Run
+func (repo *Repository[Entity]) store() *Repository[Entity] {
+	if repo.base != nil {
+		return repo.base
+	}
+	return repo
+}
+
+// transaction returns a copy of the repository with an empty Transaction
+// collection of its own, sharing the repository's persisted entities. It is
+// used by a UnitOfWork to isolate its Runs, and the repository can't be used
+// directly until the copy is released.
+func (repo *Repository[Entity]) transaction() repo {
+	store := repo.store()
+
+	if store.runs != nil {
+		store.runs.Add(1)
+	}
+
+	return &Repository[Entity]{
+		softDelete:      store.softDelete,
+		identityEqualFn: store.identityEqualFn,
+		constraints:     store.constraints,
+		identityFn:      store.identityFn,
+		keyFn:           store.keyFn,
+		keyType:         store.keyType,
+		indexes:         store.indexes,
+		base:            store,
+		mu:              store.mu,
+	}
+}
+
+// release ends the use of a copy of the repository returned by transaction
+func (repo *Repository[Entity]) release() {
+	if store := repo.store(); store.runs != nil {
+		store.runs.Add(-1)
+	}
+}
+
+// checkDirectUse returns an error if the repository is used directly while a
+// UnitOfWork Run is in progress. Changes made to it would be left in its own
+// Transaction collection rather than committed by the Run, such as when f
+// uses the repository passed to NewUnitOfWork in place of its copy.
+func (repo *Repository[Entity]) checkDirectUse() error {
+	if repo.base != nil || repo.runs == nil || repo.runs.Load() == 0 {
+		return nil
+	}
+
+	return dorkyerrors.NewConflict(
+		"repository_in_unit_of_work",
+		"repository cannot be used directly while a UnitOfWork Run uses it",
+	)
+}
+
+// identityEqual determines if two entities are the same entity
+func (repo *Repository[Entity]) identityEqual(a Entity, b Entity) bool {
+	if repo.keyFn != nil {
+		return repo.keyFn(a) == repo.keyFn(b)
+	}
+	return repo.identityEqualFn(a, b)
+}
+
+// identityKey returns the key that identifies an entity, which is its key in
+// repositories created with NewRepository, or the value returned by the
+// identityFn given to WithIdentity. Repositories without identity keys
+// identify entities by comparing them with identityEqualFn.
+func (repo *Repository[Entity]) identityKey(entity Entity) (any, bool) {
+	switch {
+	case repo.keyFn != nil:
+		return repo.keyFn(entity), true
+	case repo.identityFn != nil:
+		return repo.identityFn(entity), true
+	}
+
+	return nil, false
+}
+
+// entitySet holds entities of a repository, which are looked up by their
+// identity keys if the repository has them
+type entitySet[Entity any] struct {
+	entities []Entity
+	keys     map[any]Entity
+}
+
+// addTo adds an entity to a set of the repository's entities
+func (repo *Repository[Entity]) addTo(set *entitySet[Entity], entity Entity) {
+	set.entities = append(set.entities, entity)
+
+	if key, ok := repo.identityKey(entity); ok {
+		if set.keys == nil {
+			set.keys = make(map[any]Entity)
+		}
+
+		set.keys[key] = entity
+	}
+}
+
+// findIn returns the entity of a set with the same identity as the entity
+// provided
+func (repo *Repository[Entity]) findIn(set *entitySet[Entity], entity Entity) (Entity, bool) {
+	if key, ok := repo.identityKey(entity); ok {
+		found, ok := set.keys[key]
+		return found, ok
+	}
+
+	for _, e := range set.entities {
+		if repo.identityEqualFn(e, entity) {
+			return e, true
+		}
+	}
+
+	var zero Entity
+	return zero, false
+}
+
+// enlist adds a copy of a persisted entity to the repository's Transaction
+// collection and returns it. The copy is modified in place of the persisted
+// entity so that changes don't affect Entities until the repo is saved.
+func (repo *Repository[Entity]) enlist(entity Entity) Entity {
+	transactionEntity := entity.Clone()
+	repo.appendTransaction(transactionEntity)
+	repo.addTo(&repo.loaded, entity)
+	return transactionEntity
+}
+
+// inTransaction determines if the entity is in the repository's Transaction
+// collection
+func (repo *Repository[Entity]) inTransaction(entity Entity) bool {
+	_, ok := repo.transactionEntity(entity)
+	return ok
+}
+
+// transactionEntity returns the entity in the repository's Transaction
+// collection with the same identity as the entity provided, which is looked
+// up by its identity key if the repository has them
+func (repo *Repository[Entity]) transactionEntity(entity Entity) (Entity, bool) {
+	if key, ok := repo.identityKey(entity); ok {
+		return repo.transactionEntityByKey(key)
+	}
+
+	for _, e := range repo.Transaction {
+		if repo.identityEqualFn(e, entity) {
+			return e, true
+		}
+	}
+
+	var zero Entity
+	return zero, false
+}
+
+// transactionEntityByKey returns the entity in the repository's Transaction
+// collection with the identity key provided
+func (repo *Repository[Entity]) transactionEntityByKey(key any) (Entity, bool) {
+	repo.ensureTransactionKeys()
+
+	entity, ok := repo.transactionKeys[key]
+
+	return entity, ok
+}
+
+// ensureTransactionKeys rebuilds transactionKeys if Transaction was replaced
+// or extended without going through the repository
+func (repo *Repository[Entity]) ensureTransactionKeys() {
+	if repo.transactionKeys != nil && len(repo.Transaction) == repo.keyedLen &&
+		(len(repo.Transaction) == 0 || &repo.Transaction[0] == repo.keyed) {
+		return
+	}
+
+	repo.transactionKeys = make(map[any]Entity, len(repo.Transaction))
+
+	for _, entity := range repo.Transaction {
+		key, _ := repo.identityKey(entity)
+		repo.transactionKeys[key] = entity
+	}
+
+	repo.keyedTransaction()
+}
+
+// keyedTransaction records the Transaction that transactionKeys holds
+func (repo *Repository[Entity]) keyedTransaction() {
+	repo.keyedLen = len(repo.Transaction)
+	repo.keyed = new(Entity)
+
+	if len(repo.Transaction) > 0 {
+		repo.keyed = &repo.Transaction[0]
+	}
+}
+
+// appendTransaction adds an entity to the repository's Transaction
+// collection
+func (repo *Repository[Entity]) appendTransaction(entity Entity) {
+	key, keyed := repo.identityKey(entity)
+
+	if keyed {
+		repo.ensureTransactionKeys()
+	}
+
+	repo.Transaction = append(repo.Transaction, entity)
+
+	if keyed {
+		repo.transactionKeys[key] = entity
+		repo.keyedTransaction()
+	}
+}
+
+// deleteTransaction removes the entity at position i from the repository's
+// Transaction collection
+func (repo *Repository[Entity]) deleteTransaction(i int) {
+	key, keyed := repo.identityKey(repo.Transaction[i])
+
+	if keyed {
+		repo.ensureTransactionKeys()
+		delete(repo.transactionKeys, key)
+	}
+
+	repo.Transaction = slices.Delete(repo.Transaction, i, i+1)
+
+	if keyed {
+		repo.keyedTransaction()
+	}
+}
+
 // Add verifies that an equivalent entity doesn't already exist in the
 // repository and then adds it to the repository's uncommitted entities.
 //
-// An error with code CodeAlreadyExists will be returned if the entity
-// duplicates one that's already persisted or has uncommitted changes.
+// A *ConstraintError, which matches ErrAlreadyExists, will be returned if the
+// entity duplicates one that's already persisted or has uncommitted changes.
 func (repo *Repository[Entity]) Add(
 	toAdd Entity,
 ) error {
-	for _, entity := range append(repo.Entities, repo.Transaction...) {
-		if repo.constraintEqualFn(toAdd, entity) {
-			return ErrAlreadyExists
-		}
+	if err := repo.checkDirectUse(); err != nil {
+		return err
 	}
 
-	repo.Transaction = append(repo.Transaction, toAdd)
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	// Entities that have been removed in the transaction don't conflict, as
+	// they are deleted before added entities are persisted
+	// The values of the unique indexes of the entities in the Transaction
+	// collection may have changed since they were added, so they are
+	// compared to the entity
+	repo.ensureTransactionKeys()
+
+	unique := &uniqueEntities[Entity]{keys: repo.transactionKeys}
+
+	err := repo.checkConstraints(toAdd, repo.Transaction, unique, repo.removedPositions())
+
+	if err != nil {
+		return err
+	}
+
+	repo.appendTransaction(toAdd)
 
 	return nil
 }
@@ -89,24 +447,26 @@ func (repo *Repository[Entity]) FindOne(
 	Entity,
 	error,
 ) {
+	if err := repo.checkDirectUse(); err != nil {
+		var zero Entity
+		return zero, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
 	for _, entity := range repo.Transaction {
-		if matchFn(entity) {
+		if repo.visible(entity) && matchFn(entity) {
 			return entity, nil
 		}
 	}
 
-	for _, entity := range repo.Entities {
-		if !matchFn(entity) {
+	for _, entity := range repo.store().Entities {
+		if !repo.visiblePersisted(entity) || repo.inTransaction(entity) || !matchFn(entity) {
 			continue
 		}
 
-		// Transaction entity is a copy so that writes don't affect the persisted Entities
-		// until the repo is saved
-		transactionEntity := entity.Clone()
-
-		repo.Transaction = append(repo.Transaction, transactionEntity)
-
-		return transactionEntity, nil
+		return repo.enlist(entity), nil
 	}
 
 	var zero Entity
@@ -125,79 +485,250 @@ func (repo *Repository[Entity]) FindAll(
 	[]Entity,
 	error,
 ) {
+	if err := repo.checkDirectUse(); err != nil {
+		return nil, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
 	var all []Entity
 
 	for _, entity := range repo.Transaction {
-		if matchFn(entity) {
+		if repo.visible(entity) && matchFn(entity) {
 			all = append(all, entity)
 		}
 	}
 
-	for _, entity := range repo.Entities {
-		if !matchFn(entity) {
-			continue
-		}
-
-		// Check if an entity with matching identity is already in all
-		if slices.ContainsFunc(all, func(e Entity) bool {
-			return repo.identityEqualFn(e, entity)
-		}) {
+	for _, entity := range repo.store().Entities {
+		// Entities already in the Transaction collection were matched as they
+		// are there
+		if !repo.visiblePersisted(entity) || repo.inTransaction(entity) || !matchFn(entity) {
 			continue
 		}
 
-		transactionEntity := entity.Clone()
-		repo.Transaction = append(repo.Transaction, transactionEntity)
-
-		all = append(all, transactionEntity)
+		all = append(all, repo.enlist(entity))
 	}
 
 	return all, nil
 }
 
 // Save persists any entities found in the repository's Transaction collection into
-// its persistent store (the Entities collection)
+// its persistent store (the Entities collection). Only the entities that were
+// added or modified are persisted. Nothing is persisted if any of the entities
+// can't be.
 func (repo *Repository[Entity]) Save() ([]messages.Event, error) {
-	// Validate all constraints before persisting anything to ensure atomicity
-	for _, toSave := range repo.Transaction {
-		for _, entity := range repo.Entities {
-			if repo.identityEqualFn(entity, toSave) {
-				continue
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	changed, err := repo.prepare()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.commit(changed), nil
+}
+
+// Prepare validates that the entities in the repository's Transaction
+// collection can be persisted without changing the repository. It is the
+// first phase of committing a UnitOfWork spanning many repositories.
+func (repo *Repository[Entity]) Prepare() error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.prepareLocked()
+}
+
+// prepareLocked prepares the repository like Prepare while it is locked
+func (repo *Repository[Entity]) prepareLocked() error {
+	_, err := repo.prepare()
+	return err
+}
+
+// prepare validates the entities to be persisted, returning those that have
+// changed
+func (repo *Repository[Entity]) prepare() ([]Entity, error) {
+	if err := repo.checkDirectUse(); err != nil {
+		return nil, err
+	}
+
+	store := repo.store()
+
+	// replaced holds the positions of the persisted entities that are removed
+	// or replaced by the transaction, which the changed entities don't
+	// conflict with
+	replaced := make(map[int]bool)
+
+	for _, toRemove := range repo.removed.entities {
+		if position, ok := repo.position(toRemove); ok {
+			if err := checkVersion(store.Entities[position], toRemove); err != nil {
+				return nil, err
 			}
 
-			if repo.constraintEqualFn(entity, toSave) {
-				return nil, ErrAlreadyExists
+			replaced[position] = true
+		}
+	}
+
+	changed := repo.changedEntities()
+
+	for _, toSave := range changed {
+		if position, ok := repo.position(toSave); ok && !repo.isRemoved(toSave) {
+			if err := checkVersion(store.Entities[position], toSave); err != nil {
+				return nil, err
 			}
+
+			replaced[position] = true
+		}
+	}
+
+	// Changed entities are checked against each other, as well as against
+	// the persisted entities they don't replace
+	unique := repo.newUniqueEntities()
+
+	for i, toSave := range changed {
+		if err := repo.checkConstraints(toSave, changed[:i], unique, replaced); err != nil {
+			return nil, err
 		}
+
+		repo.addUnique(unique, toSave)
+	}
+
+	return changed, nil
+}
+
+// Commit persists the entities in the repository's Transaction collection
+// that were validated by Prepare, and returns the events they raised
+func (repo *Repository[Entity]) Commit() []messages.Event {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.commitLocked()
+}
+
+// commitLocked commits the repository like Commit while it is locked
+func (repo *Repository[Entity]) commitLocked() []messages.Event {
+	return repo.commit(repo.changedEntities())
+}
+
+// commit persists the changed entities and collects the events raised by
+// all of the entities in the Transaction collection
+func (repo *Repository[Entity]) commit(changed []Entity) []messages.Event {
+	store := repo.store()
+
+	indexing := repo.keyFn != nil || len(repo.indexes) > 0
+
+	if indexing {
+		repo.ensureIndexes()
 	}
 
 	persist := func(toSave Entity) {
 		// Replace the entity whose identity matches
-		for idx, entity := range repo.Entities {
-			if repo.identityEqualFn(entity, toSave) {
-				repo.Entities[idx] = toSave
-				return
+		if position, ok := repo.position(toSave); ok {
+			if indexing {
+				repo.unindexEntity(store.Entities[position], position)
+				repo.indexEntity(toSave, position)
 			}
+
+			store.Entities[position] = toSave
+			return
 		}
 
 		// No identiy match found, add the entity as a new entity
-		repo.Entities = append(repo.Entities, toSave)
+		store.Entities = append(store.Entities, toSave)
+
+		// The indexes are extended rather than rebuilt, even if Entities was
+		// moved by growing it
+		if indexing {
+			repo.indexEntity(toSave, len(store.Entities)-1)
+			repo.indexedEntities()
+		}
 	}
 
 	var events []messages.Event
 
+	// Removed entities are deleted first so that entities added in their
+	// place aren't deleted with them
+	for _, removed := range repo.removed.entities {
+		events = append(events, removed.GetEvents()...)
+		removed.ResetEvents()
+	}
+
+	if len(repo.removed.entities) > 0 {
+		repo.deleteRemoved()
+		repo.removed = entitySet[Entity]{}
+
+		if indexing {
+			repo.rebuildIndexes()
+		}
+	}
+
+	for _, toSave := range changed {
+		if versioned, ok := any(toSave).(Versioned); ok {
+			versioned.SetVersion(versioned.GetVersion() + 1)
+		}
+
+		persist(toSave)
+	}
+
 	for _, transactionEntity := range repo.Transaction {
-		persist(transactionEntity)
 		events = append(events, transactionEntity.GetEvents()...)
 		transactionEntity.ResetEvents()
 	}
 
 	repo.Transaction = nil
+	repo.transactionKeys = nil
+	repo.dirty = entitySet[Entity]{}
+	repo.loaded = entitySet[Entity]{}
+
+	if indexing {
+		repo.indexedEntities()
+	}
 
-	return events, nil
+	return events
 }
 
 // Reset is used to clear the repository's Transaction collection and any changes made
 // to the transaction entities are forgotten and can't be saved.
 func (repo *Repository[Entity]) Reset() {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
 	repo.Transaction = nil
+	repo.transactionKeys = nil
+	repo.removed = entitySet[Entity]{}
+	repo.dirty = entitySet[Entity]{}
+	repo.loaded = entitySet[Entity]{}
+}
+
+// Lock gives the caller exclusive use of the repository until Unlock is
+// called. It is used by a UnitOfWork to commit the changes of a Run to all of
+// its repositories at once.
+func (repo *Repository[Entity]) Lock() {
+	repo.mu.Lock()
+}
+
+// Unlock releases the repository acquired with Lock
+func (repo *Repository[Entity]) Unlock() {
+	repo.mu.Unlock()
+}
+
+// checkVersion verifies that an entity to be saved is the version of the
+// persisted entity when they are Versioned
+func checkVersion[Entity any](persisted Entity, toSave Entity) error {
+	persistedVersion, ok := any(persisted).(Versioned)
+
+	if !ok {
+		return nil
+	}
+
+	toSaveVersion := any(toSave).(Versioned)
+
+	if persistedVersion.GetVersion() == toSaveVersion.GetVersion() {
+		return nil
+	}
+
+	return ErrConcurrencyConflict.
+		WithDetail("version", toSaveVersion.GetVersion()).
+		WithDetail("persisted_version", persistedVersion.GetVersion())
 }
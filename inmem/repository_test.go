@@ -0,0 +1,143 @@
+package inmem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/aggregate"
+	"github.com/dmpettyp/dorky/inmem"
+)
+
+type document struct {
+	aggregate.Aggregate
+	ID      string
+	Title   string
+	Version int
+}
+
+func (d *document) GetVersion() int {
+	return d.Version
+}
+
+func (d *document) SetVersion(version int) {
+	d.Version = version
+}
+
+func (d *document) Clone() *document {
+	clone := *d
+	return &clone
+}
+
+func newDocumentRepository(t *testing.T) *inmem.Repository[*document] {
+	repo, err := inmem.CreateRepository(
+		func(a, b *document) bool { return a.ID == b.ID },
+		func(a, b *document) bool { return a.Title == b.Title },
+	)
+	require.NoError(t, err)
+	return &repo
+}
+
+// Test that saving Versioned entities increments their versions and rejects
+// stale writes
+func TestRepositoryVersions(t *testing.T) {
+	repo := newDocumentRepository(t)
+
+	require.NoError(t, repo.Add(&document{ID: "d1", Title: "draft"}))
+	_, err := repo.Save()
+	require.NoError(t, err)
+	require.Equal(t, 1, repo.Entities[0].Version)
+
+	doc, err := repo.FindOne(func(d *document) bool { return d.ID == "d1" })
+	require.NoError(t, err)
+	doc.Title = "final"
+
+	// The persisted document is modified after it was loaded
+	repo.Entities[0] = &document{ID: "d1", Title: "review", Version: 2}
+
+	_, err = repo.Save()
+	require.ErrorIs(t, err, inmem.ErrConcurrencyConflict)
+	require.Equal(t, "review", repo.Entities[0].Title)
+
+	repo.Reset()
+
+	doc, err = repo.FindOne(func(d *document) bool { return d.ID == "d1" })
+	require.NoError(t, err)
+	doc.Title = "final"
+
+	_, err = repo.Save()
+	require.NoError(t, err)
+	require.Equal(t, "final", repo.Entities[0].Title)
+	require.Equal(t, 3, repo.Entities[0].Version)
+}
+
+// Test that entities already in the Transaction collection are found there,
+// rather than copied again, by the keys or identities of the repository
+func TestRepositoryTransactionIdentity(t *testing.T) {
+	members := newMemberRepository(t)
+	members.Entities = []*member{
+		{ID: 1, Email: "ada@example.com", Team: "core"},
+		{ID: 2, Email: "bob@example.com", Team: "core"},
+	}
+
+	m, err := members.FindByKey(1)
+	require.NoError(t, err)
+	m.Team = "web"
+
+	// The copy in the transaction no longer matches
+	all, err := members.FindAll(func(m *member) bool { return m.Team == "core" })
+	require.NoError(t, err)
+	require.Equal(t, []int{2}, memberIDs(all))
+
+	_, err = members.FindOne(func(m *member) bool { return m.ID == 1 && m.Team == "core" })
+	require.ErrorIs(t, err, inmem.ErrNotFound)
+
+	found, err := members.FindByKey(1)
+	require.NoError(t, err)
+	require.Same(t, m, found)
+	require.Len(t, members.Transaction, 2)
+
+	err = members.Add(&member{ID: 2, Email: "cy@example.com"})
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+
+	// Entities added to the Transaction collection directly are found
+	added := &member{ID: 3, Email: "dan@example.com"}
+	members.Transaction = append(members.Transaction, added)
+
+	found, err = members.FindByKey(3)
+	require.NoError(t, err)
+	require.Same(t, added, found)
+
+	require.NoError(t, members.Remove(m))
+	require.Len(t, members.Transaction, 2)
+
+	found, err = members.FindByKey(3)
+	require.NoError(t, err)
+	require.Same(t, added, found)
+
+	_, err = members.Save()
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3}, memberIDs(members.Entities))
+
+	// Repositories created with CreateRepository use the identities given
+	// with WithIdentity
+	documents, err := inmem.CreateRepository(
+		func(a, b *document) bool { return a.ID == b.ID },
+		nil,
+		inmem.WithIdentity(func(d *document) string { return d.ID }),
+	)
+	require.NoError(t, err)
+
+	documents.Entities = []*document{{ID: "d1", Title: "draft"}}
+
+	doc, err := documents.FindOne(func(d *document) bool { return d.ID == "d1" })
+	require.NoError(t, err)
+	doc.Title = "final"
+
+	docs, err := documents.FindAll(func(d *document) bool { return d.Title == "draft" })
+	require.NoError(t, err)
+	require.Empty(t, docs)
+
+	require.NoError(t, documents.Remove(&document{ID: "d1"}))
+	require.Empty(t, documents.Transaction)
+}
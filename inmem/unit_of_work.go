@@ -1,65 +1,381 @@
 package inmem
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"unsafe"
 
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
 	"github.com/dmpettyp/dorky/messages"
 )
 
+// repo is the contract between a UnitOfWork and its Repositories. Each Run
+// works with a copy of every repo that holds the Run's uncommitted changes.
+// Changes are committed in two phases so that a UnitOfWork spanning many
+// repos commits all of their changes or none of them: every repo prepares its
+// changes, which validates them without persisting anything, and only once
+// all of them have been prepared are they committed.
 type repo interface {
-	Save() ([]messages.Event, error)
+	// transaction returns a copy of the repo with no uncommitted changes,
+	// whose changes are committed to the repo. The repo can't be used
+	// directly until the copy is released.
+	transaction() repo
+
+	// release ends the use of a copy returned by transaction
+	release()
+
+	// prepareLocked validates the repo's uncommitted changes while it is
+	// locked. commitLocked must not fail once prepareLocked has succeeded.
+	prepareLocked() error
+
+	// commitLocked persists the changes validated by prepareLocked while the
+	// repo is locked, and returns the events raised by the committed entities
+	commitLocked() []messages.Event
+
 	Reset()
+
+	// Lock gives a Run exclusive use of the repo while its changes are
+	// prepared and committed
+	sync.Locker
 }
 
 // InmemUnitOfWork provides a generic implementation of an in-memory UnitOfWork
 // with arbitrary in-memory Repositories that can be embedded within a specific
 // in-memory UnitOfWork with concrete in-memory Repositories
+//
+// Repos is a repo, or a struct or pointer to a struct holding the repos in its
+// fields, exported or not, or in the fields of structs it holds or points to.
+// Repos are *Repository or *FileRepository values, or pointers to structs that
+// embed a Repository or FileRepository.
+//
+// A UnitOfWork is safe for concurrent use. Each Run passes f a copy of Repos
+// in which every repo has a Transaction of its own, so Runs don't observe
+// each other's uncommitted changes. Structs that Repos points to are copied
+// rather than changed when they hold repos. The repos are only locked while
+// the changes of a Run are prepared and committed, which is atomic across the
+// repos, so Runs proceed concurrently and f observes the changes of other
+// Runs once they are committed. Versioned entities can't be saved by a Run
+// once another Run has saved them since they were loaded, which RunWithRetry
+// recovers from.
+//
+// f must only use the repos it is passed. While any Run is in progress, the
+// repos given to NewUnitOfWork can't be used directly to find, add, remove or
+// save entities, which fails with repository_in_unit_of_work, since changes
+// made through them would not be committed by the Run.
 type UnitOfWork[Repos any] struct {
 	repos    Repos
 	repoList []repo
+
+	// lockOrder holds the repos in the order they are locked, which is the
+	// same for every UnitOfWork so that Runs can't deadlock
+	lockOrder []repo
 }
 
 func NewUnitOfWork[Repos any](repos Repos, repoList ...repo) *UnitOfWork[Repos] {
+	lockOrder := slices.Clone(repoList)
+
+	slices.SortStableFunc(lockOrder, func(a, b repo) int {
+		return cmp.Compare(repoAddress(a), repoAddress(b))
+	})
+
+	// A repo listed twice is only locked once
+	lockOrder = slices.CompactFunc(lockOrder, func(a, b repo) bool {
+		return a == b
+	})
+
 	unitOfWork := &UnitOfWork[Repos]{
-		repos:    repos,
-		repoList: repoList,
+		repos:     repos,
+		repoList:  repoList,
+		lockOrder: lockOrder,
 	}
 	return unitOfWork
 }
 
+// repoAddress returns the address of a repo, which orders repos consistently
+// as long as they exist
+func repoAddress(r repo) uintptr {
+	v := reflect.ValueOf(r)
+
+	if v.Kind() != reflect.Pointer {
+		return 0
+	}
+
+	return v.Pointer()
+}
+
 // Run executes f in a transaction and returns the events that were created by executing f. If f returns an
-// error then the transaction will be rolled back.
+// error, or the changes to any repo can't be committed, then the transaction will be rolled back and no repo
+// is changed.
 func (uow *UnitOfWork[Repos]) Run(
 	_ context.Context,
 	f func(Repos) error,
 ) ([]messages.Event, error) {
-	resetRepos := func() {
-		for _, r := range uow.repoList {
-			r.Reset()
-		}
+	repos, transactions, err := uow.begin()
+	if err != nil {
+		return nil, err
 	}
 
-	resetRepos()
-
 	// always reset the in-mem working set of entities after the unit of work
-	// completes (whether success or failure) to prepare for the next transaction
-	defer resetRepos()
+	// completes (whether success or failure)
+	defer func() {
+		for _, r := range transactions {
+			r.Reset()
+			r.release()
+		}
+	}()
 
-	err := f(uow.repos)
+	err = f(repos)
 	if err != nil {
 		return nil, err
 	}
 
-	var committedEvents []messages.Event
+	return uow.commit(transactions)
+}
+
+// begin returns a copy of the UnitOfWork's Repos in which every repo is
+// replaced by a copy with a Transaction of its own, along with those copies
+// in the order the repos were listed
+func (uow *UnitOfWork[Repos]) begin() (Repos, []repo, error) {
+	repos := uow.repos
+	transactions := make(map[repo]repo, len(uow.repoList))
+
+	var list []repo
+
+	fail := func(r repo, reason string) (Repos, []repo, error) {
+		for _, transaction := range list {
+			transaction.release()
+		}
+
+		return repos, nil, invalidRepoError(r, reason)
+	}
+
+	for _, r := range uow.repoList {
+		if _, ok := transactions[r]; ok {
+			continue
+		}
+
+		transaction, ok := newTransaction(r)
+
+		if !ok {
+			return fail(r, "doesn't embed a Repository or FileRepository")
+		}
+
+		transactions[r] = transaction
+		list = append(list, transaction)
+	}
+
+	found := make(map[repo]bool, len(uow.repoList))
+
+	replaceRepos(reflect.ValueOf(&repos).Elem(), transactions, found, map[uintptr]reflect.Value{})
 
 	for _, r := range uow.repoList {
-		events, err := r.Save()
-		if err != nil {
+		if !found[r] {
+			return fail(r, "isn't held by the repos")
+		}
+	}
+
+	return repos, list, nil
+}
+
+// replaceRepos replaces the repos held by v, which must be addressable, with
+// their transactions, recording the repos found. Structs that v holds are
+// searched for repos, and structs it points to are copied when they hold
+// repos, which copied records by address. It reports whether v was changed.
+func replaceRepos(
+	v reflect.Value,
+	transactions map[repo]repo,
+	found map[repo]bool,
+	copied map[uintptr]reflect.Value,
+) bool {
+	// Repos held by unexported fields are replaced too
+	if !v.CanSet() {
+		v = reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		replaced := false
+
+		for i := range v.NumField() {
+			if replaceRepos(v.Field(i), transactions, found, copied) {
+				replaced = true
+			}
+		}
+
+		return replaced
+
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return false
+		}
+
+		// Repos aren't searched, whether or not they are listed
+		if r, ok := v.Interface().(repo); ok {
+			transaction, listed := transactions[r]
+
+			if listed {
+				v.Set(reflect.ValueOf(transaction))
+				found[r] = true
+			}
+
+			return listed
+		}
+
+		if v.Kind() != reflect.Pointer || v.Type().Elem().Kind() != reflect.Struct {
+			return false
+		}
+
+		// A struct pointed to more than once is copied once, and isn't
+		// searched again while it is being searched
+		if c, ok := copied[v.Pointer()]; ok {
+			if !c.IsValid() {
+				return false
+			}
+
+			v.Set(c)
+			return true
+		}
+
+		copied[v.Pointer()] = reflect.Value{}
+
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(v.Elem())
+
+		if !replaceRepos(c.Elem(), transactions, found, copied) {
+			return false
+		}
+
+		copied[v.Pointer()] = c
+		v.Set(c)
+
+		return true
+	}
+
+	return false
+}
+
+// commit prepares and then commits the changes of the transactions of a Run
+// with all of the UnitOfWork's repos locked
+func (uow *UnitOfWork[Repos]) commit(transactions []repo) ([]messages.Event, error) {
+	for _, r := range uow.lockOrder {
+		r.Lock()
+	}
+
+	defer func() {
+		for _, r := range slices.Backward(uow.lockOrder) {
+			r.Unlock()
+		}
+	}()
+
+	for _, r := range transactions {
+		if err := r.prepareLocked(); err != nil {
 			return nil, err
 		}
+	}
 
-		committedEvents = append(committedEvents, events...)
+	var committedEvents []messages.Event
+
+	for _, r := range transactions {
+		committedEvents = append(committedEvents, r.commitLocked()...)
 	}
 
 	return committedEvents, nil
 }
+
+// newTransaction returns a copy of a repo with a Transaction of its own. A
+// repo that embeds a Repository or FileRepository, possibly through other
+// structs, is copied with the embedded repository replaced by its copy.
+func newTransaction(r repo) (repo, bool) {
+	transaction := reflect.ValueOf(r.transaction())
+	v := reflect.ValueOf(r)
+
+	if transaction.Type() == v.Type() {
+		return transaction.Interface().(repo), true
+	}
+
+	if v.Type().Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	wrapper := reflect.New(v.Type().Elem())
+	wrapper.Elem().Set(v.Elem())
+
+	if !embed(wrapper.Elem(), transaction) {
+		return nil, false
+	}
+
+	return wrapper.Interface().(repo), true
+}
+
+// embed replaces the repository embedded in the struct provided, possibly
+// through other structs, by the copy of it made for a transaction. Embedded
+// structs referenced by pointers are copied rather than changed.
+func embed(wrapper reflect.Value, transaction reflect.Value) bool {
+	for i := range wrapper.NumField() {
+		field := wrapper.Field(i)
+
+		if !wrapper.Type().Field(i).Anonymous || !field.CanSet() {
+			continue
+		}
+
+		switch {
+		case field.Type() == transaction.Type():
+			field.Set(transaction)
+			return true
+
+		case field.Type() == transaction.Type().Elem():
+			field.Set(transaction.Elem())
+			return true
+
+		case field.Kind() == reflect.Struct:
+			if embed(field, transaction) {
+				return true
+			}
+
+		case field.Kind() == reflect.Pointer &&
+			field.Type().Elem().Kind() == reflect.Struct &&
+			!field.IsNil():
+			embedded := reflect.New(field.Type().Elem())
+			embedded.Elem().Set(field.Elem())
+
+			if embed(embedded.Elem(), transaction) {
+				field.Set(embedded)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func invalidRepoError(r repo, reason string) error {
+	return dorkyerrors.NewInvalid(
+		"invalid_unit_of_work", fmt.Sprintf("repo %T %s", r, reason),
+	).WithDetail("repo", fmt.Sprintf("%T", r))
+}
+
+// RunWithRetry executes f in a transaction like Run, running it again when it
+// fails with ErrConcurrencyConflict, up to the number of attempts provided.
+// f must load the entities it modifies each time it is run so that it works
+// with their latest versions. The error from the last attempt is returned.
+func (uow *UnitOfWork[Repos]) RunWithRetry(
+	ctx context.Context,
+	attempts int,
+	f func(Repos) error,
+) ([]messages.Event, error) {
+	for attempt := 1; ; attempt++ {
+		events, err := uow.Run(ctx, f)
+
+		if err == nil ||
+			attempt >= attempts ||
+			!errors.Is(err, ErrConcurrencyConflict) ||
+			ctx.Err() != nil {
+			return events, err
+		}
+	}
+}
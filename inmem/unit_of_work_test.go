@@ -0,0 +1,570 @@
+package inmem_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/aggregate"
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/inmem"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+type userCreatedEvent struct {
+	messages.BaseEvent
+}
+
+type user struct {
+	aggregate.Aggregate
+	ID    string
+	Email string
+}
+
+func newUser(id string, email string) *user {
+	u := &user{ID: id, Email: email}
+	evt := &userCreatedEvent{}
+	evt.Init("UserCreated")
+	u.AddEvent(evt)
+	return u
+}
+
+func (u *user) Clone() *user {
+	clone := *u
+	return &clone
+}
+
+type accountRenamedEvent struct {
+	messages.BaseEvent
+}
+
+type account struct {
+	aggregate.Aggregate
+	ID      string
+	Name    string
+	Members int
+}
+
+func (a *account) Rename(name string) {
+	a.Name = name
+	evt := &accountRenamedEvent{}
+	evt.Init("AccountRenamed")
+	a.AddEvent(evt)
+}
+
+func (a *account) Clone() *account {
+	clone := *a
+	return &clone
+}
+
+type testRepos struct {
+	Users    *inmem.Repository[*user]
+	Accounts *inmem.Repository[*account]
+}
+
+func newTestUnitOfWork(t *testing.T) (*inmem.UnitOfWork[testRepos], testRepos) {
+	users, err := inmem.CreateRepository(
+		func(a, b *user) bool { return a.ID == b.ID },
+		func(a, b *user) bool { return a.Email == b.Email },
+	)
+	require.NoError(t, err)
+
+	accounts, err := inmem.CreateRepository(
+		func(a, b *account) bool { return a.ID == b.ID },
+		func(a, b *account) bool { return a.Name == b.Name },
+	)
+	require.NoError(t, err)
+
+	repos := testRepos{Users: &users, Accounts: &accounts}
+
+	return inmem.NewUnitOfWork(repos, repos.Users, repos.Accounts), repos
+}
+
+// Test that the changes to every repo are committed along with their events
+func TestUnitOfWorkCommit(t *testing.T) {
+	uow, repos := newTestUnitOfWork(t)
+
+	repos.Accounts.Entities = []*account{{ID: "a1", Name: "acme"}}
+
+	events, err := uow.Run(context.Background(), func(repos testRepos) error {
+		if err := repos.Users.Add(newUser("u1", "jo@example.com")); err != nil {
+			return err
+		}
+
+		acct, err := repos.Accounts.FindOne(func(a *account) bool { return a.ID == "a1" })
+		if err != nil {
+			return err
+		}
+
+		acct.Rename("acme corp")
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, events, 2)
+	require.Equal(t, "UserCreated", events[0].GetType())
+	require.Equal(t, "AccountRenamed", events[1].GetType())
+
+	require.Len(t, repos.Users.Entities, 1)
+	require.Equal(t, "acme corp", repos.Accounts.Entities[0].Name)
+}
+
+// Test that a repo failing to commit leaves every repo unchanged, including
+// repos that could have been committed
+func TestUnitOfWorkCrossRepoFailure(t *testing.T) {
+	uow, repos := newTestUnitOfWork(t)
+
+	repos.Accounts.Entities = []*account{
+		{ID: "a1", Name: "acme"},
+		{ID: "a2", Name: "globex"},
+	}
+
+	events, err := uow.Run(context.Background(), func(repos testRepos) error {
+		if err := repos.Users.Add(newUser("u1", "jo@example.com")); err != nil {
+			return err
+		}
+
+		acct, err := repos.Accounts.FindOne(func(a *account) bool { return a.ID == "a1" })
+		if err != nil {
+			return err
+		}
+
+		// Conflicts with a2, which is only detected when committing
+		acct.Rename("globex")
+
+		return nil
+	})
+	require.ErrorIs(t, err, inmem.ErrAlreadyExists)
+	require.Nil(t, events)
+
+	require.Empty(t, repos.Users.Entities)
+	require.Empty(t, repos.Users.Transaction)
+	require.Empty(t, repos.Accounts.Transaction)
+	require.Equal(t, "acme", repos.Accounts.Entities[0].Name)
+	require.Equal(t, "globex", repos.Accounts.Entities[1].Name)
+
+	// The unit of work can be retried once the conflict is resolved
+	events, err = uow.Run(context.Background(), func(repos testRepos) error {
+		return repos.Users.Add(newUser("u1", "jo@example.com"))
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Len(t, repos.Users.Entities, 1)
+}
+
+// Test that concurrent Runs don't share their working sets
+func TestUnitOfWorkConcurrentRuns(t *testing.T) {
+	uow, repos := newTestUnitOfWork(t)
+
+	// A second unit of work over the same repos, listed in the opposite order
+	otherUOW := inmem.NewUnitOfWork(repos, repos.Accounts, repos.Users)
+
+	repos.Accounts.Entities = []*account{{ID: "a1", Name: "acme"}}
+
+	const runs = 50
+
+	var wg sync.WaitGroup
+
+	for i := range runs {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			run := uow.Run
+			if i%2 == 1 {
+				run = otherUOW.Run
+			}
+
+			_, err := run(context.Background(), func(repos testRepos) error {
+				if _, err := repos.Accounts.FindOne(func(a *account) bool { return a.ID == "a1" }); err != nil {
+					return err
+				}
+
+				return repos.Users.Add(newUser(fmt.Sprintf("u%d", i), fmt.Sprintf("%d@example.com", i)))
+			})
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	require.Len(t, repos.Users.Entities, runs)
+	require.Equal(t, "acme", repos.Accounts.Entities[0].Name)
+}
+
+// Test that two Runs on the same repo proceed at the same time, each seeing
+// the changes of the other once they are committed but never before, and
+// neither seeing the changes made using the repo directly
+func TestUnitOfWorkIsolation(t *testing.T) {
+	uow, repos := newTestUnitOfWork(t)
+
+	require.NoError(t, repos.Users.Add(newUser("u0", "al@example.com")))
+
+	added := make(chan struct{})
+	committed := make(chan error)
+
+	findUser := func(repos testRepos, id string) error {
+		_, err := repos.Users.FindOne(func(u *user) bool { return u.ID == id })
+		return err
+	}
+
+	go func() {
+		<-added
+
+		_, err := uow.Run(context.Background(), func(repos testRepos) error {
+			if err := findUser(repos, "u1"); !errors.Is(err, inmem.ErrNotFound) {
+				return fmt.Errorf("found uncommitted user: %v", err)
+			}
+
+			return repos.Users.Add(newUser("u2", "bo@example.com"))
+		})
+
+		committed <- err
+	}()
+
+	_, err := uow.Run(context.Background(), func(repos testRepos) error {
+		require.ErrorIs(t, findUser(repos, "u0"), inmem.ErrNotFound)
+		require.NoError(t, repos.Users.Add(newUser("u1", "jo@example.com")))
+
+		close(added)
+		require.NoError(t, <-committed)
+
+		return findUser(repos, "u2")
+	})
+	require.NoError(t, err)
+
+	require.Len(t, repos.Users.Entities, 2)
+	require.Len(t, repos.Users.Transaction, 1)
+	require.Equal(t, "u0", repos.Users.Transaction[0].ID)
+}
+
+// Test that a Run started while running another commits independently
+func TestUnitOfWorkNestedRun(t *testing.T) {
+	uow, repos := newTestUnitOfWork(t)
+
+	_, err := uow.Run(context.Background(), func(outer testRepos) error {
+		if err := outer.Users.Add(newUser("u1", "jo@example.com")); err != nil {
+			return err
+		}
+
+		_, err := uow.Run(context.Background(), func(inner testRepos) error {
+			return inner.Users.Add(newUser("u2", "bo@example.com"))
+		})
+		if err != nil {
+			return err
+		}
+
+		require.Len(t, repos.Users.Entities, 1)
+
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, repos.Users.Entities, 2)
+}
+
+type userRepository struct {
+	*inmem.Repository[*user]
+}
+
+func (repo *userRepository) FindByEmail(email string) (*user, error) {
+	return repo.FindOne(func(u *user) bool { return u.Email == email })
+}
+
+type userRepos struct {
+	Users *userRepository
+}
+
+// Test that Runs are given copies of repositories that embed a Repository
+func TestUnitOfWorkEmbeddedRepository(t *testing.T) {
+	users, err := inmem.CreateRepository(
+		func(a, b *user) bool { return a.ID == b.ID },
+		nil,
+	)
+	require.NoError(t, err)
+
+	repos := userRepos{Users: &userRepository{Repository: &users}}
+	repos.Users.Entities = []*user{{ID: "u1", Email: "jo@example.com"}}
+
+	uow := inmem.NewUnitOfWork(repos, repos.Users)
+
+	_, err = uow.Run(context.Background(), func(txRepos userRepos) error {
+		require.NotSame(t, repos.Users, txRepos.Users)
+
+		u, err := txRepos.Users.FindByEmail("jo@example.com")
+		if err != nil {
+			return err
+		}
+
+		u.Email = "joanne@example.com"
+
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "joanne@example.com", repos.Users.Entities[0].Email)
+	require.Empty(t, repos.Users.Transaction)
+
+	// Repos must hold every repo listed
+	missing := inmem.NewUnitOfWork(userRepos{}, repos.Users)
+
+	_, err = missing.Run(context.Background(), func(userRepos) error {
+		return nil
+	})
+	require.Equal(t, "invalid_unit_of_work", dorkyerrors.CodeOf(err))
+}
+
+type nestedRepos struct {
+	users    *userRepository
+	accounts struct {
+		Accounts *inmem.Repository[*account]
+	}
+	shared *testRepos
+	again  *testRepos
+}
+
+// Test that repos are replaced in unexported fields, nested structs and the
+// structs Repos points to, which are copied rather than changed
+func TestUnitOfWorkNestedRepos(t *testing.T) {
+	_, base := newTestUnitOfWork(t)
+
+	repos := &nestedRepos{
+		users:  &userRepository{Repository: base.Users},
+		shared: &base,
+	}
+	repos.again = repos.shared
+	repos.accounts.Accounts = base.Accounts
+
+	uow := inmem.NewUnitOfWork(repos, repos.users, base.Accounts)
+
+	_, err := uow.Run(context.Background(), func(txRepos *nestedRepos) error {
+		require.NotSame(t, repos, txRepos)
+		require.NotSame(t, repos.users, txRepos.users)
+		require.NotSame(t, base.Accounts, txRepos.accounts.Accounts)
+		require.Same(t, txRepos.accounts.Accounts, txRepos.shared.Accounts)
+		require.Same(t, txRepos.shared, txRepos.again)
+
+		// A repo that isn't listed is used directly
+		require.Same(t, base.Users, txRepos.shared.Users)
+
+		if err := txRepos.users.Add(newUser("u1", "jo@example.com")); err != nil {
+			return err
+		}
+
+		return txRepos.shared.Accounts.Add(&account{ID: "a1", Name: "acme"})
+	})
+	require.NoError(t, err)
+
+	require.Same(t, base.Accounts, repos.accounts.Accounts)
+	require.Same(t, &base, repos.shared)
+	require.Len(t, base.Users.Entities, 1)
+	require.Len(t, base.Accounts.Entities, 1)
+}
+
+// Test that the repos given to a UnitOfWork can't be used directly while a
+// Run is in progress
+func TestUnitOfWorkDirectUse(t *testing.T) {
+	uow, repos := newTestUnitOfWork(t)
+
+	repos.Accounts.Entities = []*account{{ID: "a1", Name: "acme"}}
+
+	_, err := uow.Run(context.Background(), func(testRepos) error {
+		err := repos.Users.Add(newUser("u1", "jo@example.com"))
+		require.Equal(t, "repository_in_unit_of_work", dorkyerrors.CodeOf(err))
+
+		_, err = repos.Accounts.FindOne(func(a *account) bool { return a.ID == "a1" })
+		require.Equal(t, "repository_in_unit_of_work", dorkyerrors.CodeOf(err))
+
+		for _, err := range repos.Accounts.All(nil) {
+			require.Equal(t, "repository_in_unit_of_work", dorkyerrors.CodeOf(err))
+		}
+
+		_, err = repos.Accounts.Save()
+		require.Equal(t, "repository_in_unit_of_work", dorkyerrors.CodeOf(err))
+
+		// Reading the repos doesn't change them
+		count, err := repos.Accounts.Count(nil)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+
+		return nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, repos.Users.Transaction)
+	require.Empty(t, repos.Accounts.Transaction)
+
+	// The repos can be used directly once the Run is over, even if it fails
+	_, err = uow.Run(context.Background(), func(testRepos) error {
+		return errors.New("failed")
+	})
+	require.Error(t, err)
+
+	require.NoError(t, repos.Users.Add(newUser("u1", "jo@example.com")))
+	_, err = repos.Users.Save()
+	require.NoError(t, err)
+}
+
+// Test that a failed Run doesn't affect concurrent Runs
+func TestUnitOfWorkConcurrentFailures(t *testing.T) {
+	uow, repos := newTestUnitOfWork(t)
+
+	var wg sync.WaitGroup
+
+	for i := range 50 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := uow.Run(context.Background(), func(repos testRepos) error {
+				err := repos.Users.Add(newUser(fmt.Sprintf("u%d", i), fmt.Sprintf("%d@example.com", i)))
+				if err != nil {
+					return err
+				}
+
+				if i%2 == 1 {
+					return fmt.Errorf("run %d failed", i)
+				}
+
+				return nil
+			})
+
+			if i%2 == 1 {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	require.Len(t, repos.Users.Entities, 25)
+	require.Empty(t, repos.Users.Transaction)
+}
+
+type documentRepos struct {
+	Documents *inmem.Repository[*document]
+}
+
+// Test that Runs failing with a concurrency conflict are retried up to the
+// number of attempts provided
+func TestUnitOfWorkRunWithRetry(t *testing.T) {
+	repos := documentRepos{Documents: newDocumentRepository(t)}
+	repos.Documents.Entities = []*document{{ID: "d1", Title: "draft", Version: 1}}
+
+	uow := inmem.NewUnitOfWork(repos, repos.Documents)
+
+	attempts := 0
+
+	_, err := uow.RunWithRetry(context.Background(), 3, func(repos documentRepos) error {
+		attempts++
+
+		doc, err := repos.Documents.FindOne(func(d *document) bool { return d.ID == "d1" })
+		if err != nil {
+			return err
+		}
+
+		// The first attempt writes a version that has since been replaced
+		if attempts == 1 {
+			doc.Version = 0
+		}
+
+		doc.Title = "final"
+
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, attempts)
+	require.Equal(t, "final", repos.Documents.Entities[0].Title)
+	require.Equal(t, 2, repos.Documents.Entities[0].Version)
+
+	// Attempts are bounded
+	attempts = 0
+
+	_, err = uow.RunWithRetry(context.Background(), 3, func(repos documentRepos) error {
+		attempts++
+
+		doc, err := repos.Documents.FindOne(func(d *document) bool { return d.ID == "d1" })
+		if err != nil {
+			return err
+		}
+
+		doc.Version = 0
+
+		return nil
+	})
+	require.ErrorIs(t, err, inmem.ErrConcurrencyConflict)
+	require.Equal(t, 3, attempts)
+
+	// Other errors aren't retried
+	attempts = 0
+
+	_, err = uow.RunWithRetry(context.Background(), 3, func(repos documentRepos) error {
+		attempts++
+		return errors.New("failed")
+	})
+	require.EqualError(t, err, "failed")
+	require.Equal(t, 1, attempts)
+}
+
+// Test that of two Runs saving the same Versioned entity, the one that
+// commits last conflicts, and succeeds once it is retried
+func TestUnitOfWorkConcurrentVersions(t *testing.T) {
+	repos := documentRepos{Documents: newDocumentRepository(t)}
+	repos.Documents.Entities = []*document{{ID: "d1", Title: "draft", Version: 1}}
+
+	uow := inmem.NewUnitOfWork(repos, repos.Documents)
+
+	// Both Runs load the document before either of them commits
+	var loaded sync.WaitGroup
+	loaded.Add(2)
+
+	attempts := make([]int, 2)
+
+	var wg sync.WaitGroup
+
+	for i, title := range []string{"first", "second"} {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := uow.RunWithRetry(context.Background(), 2, func(repos documentRepos) error {
+				attempts[i]++
+
+				doc, err := repos.Documents.FindOne(func(d *document) bool { return d.ID == "d1" })
+				if err != nil {
+					return err
+				}
+
+				doc.Title = title
+
+				if attempts[i] == 1 {
+					loaded.Done()
+					loaded.Wait()
+				}
+
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	require.ElementsMatch(t, []int{1, 2}, attempts)
+	require.Equal(t, 3, repos.Documents.Entities[0].Version)
+
+	// The Run that was retried saved its title over the other's
+	retried := "first"
+	if attempts[1] == 2 {
+		retried = "second"
+	}
+	require.Equal(t, retried, repos.Documents.Entities[0].Title)
+}
@@ -1,6 +1,13 @@
 package mapper
 
-import "fmt"
+import (
+	"fmt"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+)
+
+// ErrNoMapping is returned when a value has no mapping
+var ErrNoMapping = dorkyerrors.NewNotFound("mapping_not_found", "no mapping found")
 
 type Mapper[From, To comparable] struct {
 	to   map[From]To
@@ -19,7 +26,9 @@ func New[From, To comparable](
 	}
 
 	if len(values)%2 == 1 {
-		return nil, fmt.Errorf("odd number of key/values")
+		return nil, dorkyerrors.NewInvalid(
+			"invalid_mapping", "odd number of key/values",
+		)
 	}
 
 	for {
@@ -30,25 +39,35 @@ func New[From, To comparable](
 		k, ok := values[0].(From)
 
 		if !ok {
-			return nil, fmt.Errorf(
-				"expected key of type %T, got %T", *new(From), values[0],
+			return nil, dorkyerrors.NewInvalid(
+				"invalid_mapping",
+				fmt.Sprintf(
+					"expected key of type %T, got %T", *new(From), values[0],
+				),
 			)
 		}
 
 		if _, ok := m.to[k]; ok {
-			return nil, fmt.Errorf("key already exists")
+			return nil, dorkyerrors.NewInvalid(
+				"invalid_mapping", "key already exists",
+			)
 		}
 
 		v, ok := values[1].(To)
 
 		if !ok {
-			return nil, fmt.Errorf(
-				"expected value of type %T, got %T", *new(To), values[1],
+			return nil, dorkyerrors.NewInvalid(
+				"invalid_mapping",
+				fmt.Sprintf(
+					"expected value of type %T, got %T", *new(To), values[1],
+				),
 			)
 		}
 
 		if _, ok := m.from[v]; ok {
-			return nil, fmt.Errorf("value already exists")
+			return nil, dorkyerrors.NewInvalid(
+				"invalid_mapping", "value already exists",
+			)
 		}
 
 		m.to[k] = v
@@ -77,7 +96,7 @@ func (m *Mapper[From, To]) To(from From) (To, error) {
 		return to, nil
 	}
 	var zero To
-	return zero, fmt.Errorf("no mapping found")
+	return zero, ErrNoMapping
 }
 
 func (m *Mapper[From, To]) ToWithDefault(from From, def To) To {
@@ -92,7 +111,7 @@ func (m *Mapper[From, To]) From(to To) (From, error) {
 		return from, nil
 	}
 	var zero From
-	return zero, fmt.Errorf("no mapping found")
+	return zero, ErrNoMapping
 }
 
 func (m *Mapper[From, To]) FromWithDefault(to To, def From) From {
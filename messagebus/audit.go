@@ -0,0 +1,244 @@
+package messagebus
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// AuditRecord records a command dispatched by the MessageBus and its outcome
+type AuditRecord struct {
+	CommandID     string         `json:"command_id"`
+	CommandType   string         `json:"command_type"`
+	CausationID   string         `json:"causation_id,omitempty"`
+	CorrelationID string         `json:"correlation_id,omitempty"`
+	Actor         string         `json:"actor,omitempty"`
+	Payload       any            `json:"payload"`
+	DispatchedAt  time.Time      `json:"dispatched_at"`
+	Duration      time.Duration  `json:"duration"`
+	Status        string         `json:"status"`
+	Error         string         `json:"error,omitempty"`
+	Events        []AuditedEvent `json:"events,omitempty"`
+}
+
+// AuditedEvent identifies an event dispatched as a result of an audited
+// command
+type AuditedEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// AuditSink receives an AuditRecord for every command dispatched by the
+// MessageBus once the command's cascade of events has been dispatched.
+// Commands issued by policies are audited as well.
+type AuditSink interface {
+	WriteAudit(ctx context.Context, record AuditRecord) error
+}
+
+// WithAuditSink records every command dispatched by the MessageBus to sink.
+//
+// A command's payload is recorded as its JSON representation. Fields tagged
+// with `audit:"redact"` are replaced with "[REDACTED]".
+func WithAuditSink(sink AuditSink) Option {
+	return func(mb *MessageBus) {
+		mb.audit = sink
+	}
+}
+
+type actorKey struct{}
+
+// WithActor returns a context that identifies the actor sending commands
+// with it, which is recorded in the audit log
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set on ctx with WithActor
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// beginAudit starts the audit record of a command sent by actor. Commands
+// issued by policies are recorded with the actor of their cascade, so that
+// the record doesn't depend on whether the MessageBus is started.
+func (mb *MessageBus) beginAudit(command messages.Command, actor string) {
+	if mb.audit == nil {
+		return
+	}
+
+	mb.auditRecord = &AuditRecord{
+		CommandID:    command.GetID().String(),
+		CommandType:  command.GetType(),
+		Actor:        actor,
+		Payload:      redactedPayload(command),
+		DispatchedAt: time.Now().UTC(),
+	}
+	mb.auditStart = time.Now()
+
+	if causationID := command.GetCausationID(); !causationID.IsNil() {
+		mb.auditRecord.CausationID = causationID.String()
+	}
+
+	if correlationID := command.GetCorrelationID(); !correlationID.IsNil() {
+		mb.auditRecord.CorrelationID = correlationID.String()
+	}
+}
+
+func (mb *MessageBus) completeAudit(err error) {
+	if mb.auditRecord == nil {
+		return
+	}
+
+	mb.auditRecord.Duration = time.Since(mb.auditStart)
+	mb.auditRecord.Status = handlerStatus(err)
+
+	if err != nil {
+		mb.auditRecord.Error = err.Error()
+	}
+}
+
+func (mb *MessageBus) auditEvent(event messages.Event) {
+	if mb.auditRecord == nil {
+		return
+	}
+
+	mb.auditRecord.Events = append(mb.auditRecord.Events, AuditedEvent{
+		ID:   event.GetID().String(),
+		Type: event.GetType(),
+	})
+}
+
+// writeAudit writes the audit record of the command whose cascade has been
+// dispatched to the AuditSink
+func (mb *MessageBus) writeAudit(ctx context.Context) {
+	if mb.auditRecord == nil {
+		return
+	}
+
+	record := *mb.auditRecord
+	mb.auditRecord = nil
+
+	if err := mb.audit.WriteAudit(ctx, record); err != nil {
+		mb.logger.Error("writing audit record failed", "error", err.Error())
+	}
+}
+
+const redacted = "[REDACTED]"
+
+// redactedPayload returns the JSON representation of v as generic values,
+// with the fields tagged `audit:"redact"` replaced
+func redactedPayload(v any) any {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return map[string]any{"error": "cannot marshal payload: " + err.Error()}
+	}
+
+	var payload any
+
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return map[string]any{"error": "cannot unmarshal payload: " + err.Error()}
+	}
+
+	return redact(reflect.ValueOf(v), payload)
+}
+
+// redact replaces the values of redacted fields of rv within payload, the
+// JSON representation of rv
+func redact(rv reflect.Value, payload any) any {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return payload
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		fields, ok := payload.(map[string]any)
+
+		if ok {
+			redactStruct(rv, fields)
+		}
+	case reflect.Slice, reflect.Array:
+		elems, ok := payload.([]any)
+
+		if ok {
+			for i := range min(rv.Len(), len(elems)) {
+				elems[i] = redact(rv.Index(i), elems[i])
+			}
+		}
+	}
+
+	return payload
+}
+
+func redactStruct(rv reflect.Value, fields map[string]any) {
+	rt := rv.Type()
+
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		name, tagged := jsonFieldName(field)
+
+		if name == "-" {
+			continue
+		}
+
+		// Fields of untagged embedded structs are promoted to the parent
+		if field.Anonymous && !tagged {
+			fieldValue := rv.Field(i)
+
+			for fieldValue.Kind() == reflect.Pointer {
+				if fieldValue.IsNil() {
+					break
+				}
+				fieldValue = fieldValue.Elem()
+			}
+
+			if fieldValue.Kind() == reflect.Struct {
+				redactStruct(fieldValue, fields)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		value, ok := fields[name]
+
+		if !ok {
+			continue
+		}
+
+		if field.Tag.Get("audit") == "redact" {
+			fields[name] = redacted
+			continue
+		}
+
+		fields[name] = redact(rv.Field(i), value)
+	}
+}
+
+// jsonFieldName returns the name of the field in its JSON representation, and
+// whether the name was set with a json tag
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+
+	if tag == "-" {
+		return "-", true
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+
+	if name == "" {
+		return field.Name, false
+	}
+
+	return name, true
+}
@@ -0,0 +1,197 @@
+package messagebus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+// MemoryAuditSink keeps audit records in memory
+type MemoryAuditSink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func NewMemoryAuditSink() *MemoryAuditSink {
+	return &MemoryAuditSink{}
+}
+
+func (s *MemoryAuditSink) WriteAudit(_ context.Context, record AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, record)
+
+	return nil
+}
+
+// Records returns the audit records written so far
+func (s *MemoryAuditSink) Records() []AuditRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.records)
+}
+
+// WriterAuditSink writes audit records to an io.Writer as JSON Lines
+type WriterAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterAuditSink(w io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{w: w}
+}
+
+func (s *WriterAuditSink) WriteAudit(_ context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("cannot write audit record: %w", err)
+	}
+
+	return nil
+}
+
+// FileAuditSink writes audit records to a file as JSON Lines. Once the file
+// would exceed its maximum size it is rotated: path is renamed to path.1,
+// path.1 to path.2 and so on, keeping at most maxBackups rotated files.
+type FileAuditSink struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileAuditSink creates a FileAuditSink appending to the file at path. A
+// maxBytes of 0 disables rotation.
+func NewFileAuditSink(
+	path string,
+	maxBytes int64,
+	maxBackups int,
+) (
+	*FileAuditSink,
+	error,
+) {
+	s := &FileAuditSink{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileAuditSink) WriteAudit(_ context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit record: %w", err)
+	}
+
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("cannot write audit record: audit file is closed")
+	}
+
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+
+	if err != nil {
+		return fmt.Errorf("cannot write audit record: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the audit file
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+func (s *FileAuditSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return fmt.Errorf("cannot open audit file: %w", err)
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot stat audit file: %w", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+
+	return nil
+}
+
+func (s *FileAuditSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("cannot close audit file for rotation: %w", err)
+	}
+
+	s.file = nil
+
+	if s.maxBackups > 0 {
+		// Discard the oldest backup if there are already maxBackups
+		os.Remove(s.backupPath(s.maxBackups))
+
+		for n := s.maxBackups - 1; n >= 1; n-- {
+			os.Rename(s.backupPath(n), s.backupPath(n+1))
+		}
+
+		if err := os.Rename(s.path, s.backupPath(1)); err != nil {
+			return fmt.Errorf("cannot rotate audit file: %w", err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("cannot rotate audit file: %w", err)
+	}
+
+	return s.open()
+}
+
+func (s *FileAuditSink) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", s.path, n)
+}
@@ -0,0 +1,199 @@
+package messagebus_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+type auditCard struct {
+	Number string `json:"number" audit:"redact"`
+	Expiry string `json:"expiry"`
+}
+
+type auditPayCommand struct {
+	messages.BaseCommand
+	Amount   int         `json:"amount"`
+	Password string      `json:"password" audit:"redact"`
+	Cards    []auditCard `json:"cards"`
+}
+
+type auditPaidEvent struct {
+	messages.BaseEvent
+}
+
+func newAuditMessageBus(t *testing.T, sink messagebus.AuditSink) *messagebus.MessageBus {
+	mb := messagebus.New(messagebus.WithAuditSink(sink), messagebus.WithInlineDispatch())
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *auditPayCommand) ([]messages.Event, error) {
+		if cmd.Amount < 0 {
+			return nil, fmt.Errorf("negative amount")
+		}
+		evt := &auditPaidEvent{}
+		evt.Init("Paid")
+		return []messages.Event{evt}, nil
+	})
+	require.NoError(t, err)
+
+	return mb
+}
+
+// Test that commands, their outcome and their events are audited with
+// redacted fields
+func TestAudit(t *testing.T) {
+	sink := messagebus.NewMemoryAuditSink()
+	mb := newAuditMessageBus(t, sink)
+
+	cmd := &auditPayCommand{
+		Amount:   10,
+		Password: "secret",
+		Cards:    []auditCard{{Number: "4111", Expiry: "12/30"}},
+	}
+	cmd.Init("Pay")
+
+	ctx := messagebus.WithActor(context.Background(), "alice")
+
+	require.NoError(t, mb.HandleCommand(ctx, cmd))
+
+	failing := &auditPayCommand{Amount: -1}
+	failing.Init("Pay")
+
+	require.Error(t, mb.HandleCommand(context.Background(), failing))
+
+	records := sink.Records()
+	require.Len(t, records, 2)
+
+	record := records[0]
+	require.Equal(t, cmd.ID.String(), record.CommandID)
+	require.Equal(t, "Pay", record.CommandType)
+	require.Equal(t, "alice", record.Actor)
+	require.Equal(t, "success", record.Status)
+	require.Len(t, record.Events, 1)
+	require.Equal(t, "Paid", record.Events[0].Type)
+
+	payload := record.Payload.(map[string]any)
+	require.Equal(t, float64(10), payload["amount"])
+	require.Equal(t, "[REDACTED]", payload["password"])
+	require.Equal(t, "Pay", payload["type"])
+
+	card := payload["cards"].([]any)[0].(map[string]any)
+	require.Equal(t, "[REDACTED]", card["number"])
+	require.Equal(t, "12/30", card["expiry"])
+
+	require.Equal(t, "error", records[1].Status)
+	require.Equal(t, "negative amount", records[1].Error)
+	require.Empty(t, records[1].Events)
+}
+
+type auditReceiptCommand struct {
+	messages.BaseCommand
+}
+
+// Test that commands issued by policies are audited with the actor of the
+// command that caused them, whether or not the MessageBus is started
+func TestAuditPolicyActor(t *testing.T) {
+	for name, opts := range map[string][]messagebus.Option{
+		"inline":  {messagebus.WithInlineDispatch()},
+		"started": nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			sink := messagebus.NewMemoryAuditSink()
+			mb := messagebus.New(append(opts, messagebus.WithAuditSink(sink))...)
+
+			err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *auditPayCommand) ([]messages.Event, error) {
+				evt := &auditPaidEvent{}
+				evt.Init("Paid")
+				return []messages.Event{evt}, nil
+			})
+			require.NoError(t, err)
+
+			err = messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *auditReceiptCommand) ([]messages.Event, error) {
+				return nil, nil
+			})
+			require.NoError(t, err)
+
+			err = messagebus.RegisterPolicy(mb, func(ctx context.Context, evt *auditPaidEvent) ([]messages.Command, error) {
+				cmd := &auditReceiptCommand{}
+				cmd.Init("SendReceipt")
+				return []messages.Command{cmd}, nil
+			})
+			require.NoError(t, err)
+
+			if opts == nil {
+				go mb.Start(context.Background())
+			}
+			defer mb.Stop()
+
+			cmd := &auditPayCommand{Amount: 10}
+			cmd.Init("Pay")
+
+			require.NoError(t, mb.HandleCommand(messagebus.WithActor(context.Background(), "alice"), cmd))
+
+			require.Eventually(t, func() bool {
+				return len(sink.Records()) == 2
+			}, time.Second, time.Millisecond)
+
+			records := sink.Records()
+			require.Equal(t, "SendReceipt", records[1].CommandType)
+			require.Equal(t, "alice", records[0].Actor)
+			require.Equal(t, "alice", records[1].Actor)
+		})
+	}
+}
+
+// Test that the file audit sink writes JSON Lines and rotates its file
+func TestFileAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	sink, err := messagebus.NewFileAuditSink(path, 600, 2)
+	require.NoError(t, err)
+
+	mb := newAuditMessageBus(t, sink)
+
+	for amount := range 10 {
+		cmd := &auditPayCommand{Amount: amount}
+		cmd.Init("Pay")
+		require.NoError(t, mb.HandleCommand(context.Background(), cmd))
+	}
+
+	require.NoError(t, sink.Close())
+
+	var amounts []float64
+
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		file, err := os.Open(name)
+		require.NoError(t, err)
+
+		info, err := file.Stat()
+		require.NoError(t, err)
+		require.LessOrEqual(t, info.Size(), int64(600))
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record messagebus.AuditRecord
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			amounts = append(amounts, record.Payload.(map[string]any)["amount"].(float64))
+		}
+		file.Close()
+	}
+
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+
+	// Only the most recent records are kept, in order
+	require.NotEmpty(t, amounts)
+	require.Equal(t, float64(9), amounts[len(amounts)-1])
+	for i := 1; i < len(amounts); i++ {
+		require.Equal(t, amounts[i-1]+1, amounts[i])
+	}
+}
@@ -0,0 +1,69 @@
+package messagebus
+
+import (
+	"context"
+	"fmt"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// HandleCommandAndAwait handles the command and waits for an event of type E
+// that resulted from it, directly or through a chain of event handlers and
+// policies, and is accepted by match. A nil match accepts the first such
+// event. Events are correlated with the command using their correlation ID,
+// so the command must have been initialized with an ID.
+//
+// The subscription for the event is made before the command is dispatched so
+// the event can't be missed. ctx bounds both handling the command and waiting
+// for the event.
+func HandleCommandAndAwait[E messages.Event](
+	ctx context.Context,
+	mb *MessageBus,
+	command messages.Command,
+	match func(E) bool,
+) (
+	E,
+	error,
+) {
+	var zero E
+
+	if command.GetID().IsNil() {
+		return zero, dorkyerrors.NewInvalid(
+			"command_not_initialized",
+			"cannot await events for a command without an ID",
+		)
+	}
+
+	correlation := correlationID(command.GetID().ID, command.GetCorrelationID())
+	isE := EventsOfType[E]()
+
+	// Only the first matching event is kept, so matching happens as events
+	// are dispatched rather than once they are received
+	events, cancel := mb.Subscribe(
+		func(evt messages.Event) bool {
+			return isE(evt) &&
+				evt.GetCorrelationID() == correlation &&
+				(match == nil || match(evt.(E)))
+		},
+		WithSubscriptionBuffer(1),
+		WithOverflowPolicy(OverflowDropNewest),
+	)
+	defer cancel()
+
+	if err := mb.HandleCommand(ctx, command); err != nil {
+		return zero, err
+	}
+
+	select {
+	case evt := <-events:
+		return evt.(E), nil
+	case <-ctx.Done():
+		return zero, dorkyerrors.Wrap(
+			ctx.Err(),
+			dorkyerrors.Unavailable,
+			"await_timeout",
+			fmt.Sprintf("no %T resulted from command %s", zero, command.GetType()),
+		)
+	}
+}
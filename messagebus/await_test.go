@@ -0,0 +1,95 @@
+package messagebus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+type capturePaymentCommand struct {
+	messages.BaseCommand
+	Amount int
+}
+
+type paymentAuthorizedEvent struct {
+	messages.BaseEvent
+	Amount int
+}
+
+type settlePaymentCommand struct {
+	messages.BaseCommand
+	Amount int
+}
+
+type paymentCapturedEvent struct {
+	messages.BaseEvent
+	Amount int
+}
+
+// Test awaiting an event that results from a command through a policy
+func TestHandleCommandAndAwait(t *testing.T) {
+	for _, opts := range [][]messagebus.Option{nil, {messagebus.WithInlineDispatch()}} {
+		mb := messagebus.New(opts...)
+
+		err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *capturePaymentCommand) ([]messages.Event, error) {
+			evt := &paymentAuthorizedEvent{Amount: cmd.Amount}
+			evt.Init("PaymentAuthorized")
+			return []messages.Event{evt}, nil
+		})
+		require.NoError(t, err)
+
+		err = messagebus.RegisterPolicy(mb, func(ctx context.Context, evt *paymentAuthorizedEvent) ([]messages.Command, error) {
+			cmd := &settlePaymentCommand{Amount: evt.Amount}
+			cmd.Init("SettlePayment")
+			return []messages.Command{cmd}, nil
+		})
+		require.NoError(t, err)
+
+		err = messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *settlePaymentCommand) ([]messages.Event, error) {
+			evt := &paymentCapturedEvent{Amount: cmd.Amount}
+			evt.Init("PaymentCaptured")
+			return []messages.Event{evt}, nil
+		})
+		require.NoError(t, err)
+
+		go mb.Start(context.Background())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+
+		// An unrelated command producing the same event type isn't matched
+		other := &settlePaymentCommand{Amount: 99}
+		other.Init("SettlePayment")
+		require.NoError(t, mb.HandleCommand(ctx, other))
+
+		cmd := &capturePaymentCommand{Amount: 5}
+		cmd.Init("CapturePayment")
+
+		captured, err := messagebus.HandleCommandAndAwait(ctx, mb, cmd, func(evt *paymentCapturedEvent) bool {
+			return evt.Amount > 0
+		})
+		require.NoError(t, err)
+		require.Equal(t, 5, captured.Amount)
+		require.Equal(t, cmd.ID.ID, captured.CorrelationID)
+
+		cancel()
+
+		// Times out when no matching event results from the command
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+
+		cmd = &capturePaymentCommand{Amount: 5}
+		cmd.Init("CapturePayment")
+
+		_, err = messagebus.HandleCommandAndAwait(ctx, mb, cmd, func(evt *paymentCapturedEvent) bool {
+			return evt.Amount > 10
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		cancel()
+		mb.Stop()
+	}
+}
@@ -0,0 +1,258 @@
+package messagebus
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// Bridge forwards selected events dispatched on a source MessageBus to a
+// target MessageBus, for example to deliver integration events between
+// bounded contexts that each have their own MessageBus.
+//
+// Events are delivered asynchronously: the source MessageBus hands events to
+// the Bridge without waiting for the target MessageBus, so neither MessageBus
+// can deadlock on the other, even when Bridges run in both directions.
+//
+// Events that reached the source MessageBus through a Bridge from the target
+// MessageBus, directly or via other Bridges, are never forwarded back to the
+// target to prevent delivery loops. Forwarded events are tracked by their
+// IDs, so new events that handlers of the target emit in reaction to them,
+// such as replies, are forwarded like any other event.
+type Bridge struct {
+	source *MessageBus
+	target *MessageBus
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending []bridgeDelivery
+	signal  chan struct{}
+	done    chan struct{}
+
+	stopOnce sync.Once
+}
+
+type bridgeDelivery struct {
+	ctx    context.Context
+	events []messages.Event
+}
+
+type BridgeOption func(*Bridge)
+
+func WithBridgeLogger(logger *slog.Logger) BridgeOption {
+	return func(b *Bridge) {
+		b.logger = logger
+	}
+}
+
+// NewBridge creates a Bridge from the source to the target MessageBus. Events
+// to forward are selected with ForwardEvent and TranslateEvent before the
+// source MessageBus is started. The Bridge only delivers events while it is
+// running.
+func NewBridge(source *MessageBus, target *MessageBus, opts ...BridgeOption) *Bridge {
+	b := &Bridge{
+		source: source,
+		target: target,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.logger == nil {
+		b.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return b
+}
+
+// ForwardEvent forwards events of type E from the Bridge's source to its
+// target unchanged. If filter is not nil only events it accepts are
+// forwarded.
+func ForwardEvent[E messages.Event](b *Bridge, filter func(E) bool) error {
+	return TranslateEvent(b, func(evt E) ([]messages.Event, error) {
+		if filter != nil && !filter(evt) {
+			return nil, nil
+		}
+		return []messages.Event{evt}, nil
+	})
+}
+
+// TranslateEvent forwards the events returned by translate for each event of
+// type E dispatched on the Bridge's source, allowing domain events to be
+// translated into integration events for the target. Returning no events
+// filters the event out.
+func TranslateEvent[E messages.Event](
+	b *Bridge,
+	translate func(E) ([]messages.Event, error),
+) error {
+	return RegisterEventHandler(
+		b.source,
+		func(ctx context.Context, evt E) ([]messages.Event, error) {
+			visited := bridgedVia(ctx, evt)
+
+			if slices.Contains(visited, b.target) {
+				return nil, nil
+			}
+
+			events, err := translate(evt)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(events) > 0 {
+				b.enqueue(ctx, events, append(slices.Clip(visited), b.source))
+			}
+
+			return nil, nil
+		},
+	)
+}
+
+// Start delivers forwarded events to the target MessageBus until ctx is
+// cancelled or the Bridge is stopped
+func (b *Bridge) Start(ctx context.Context) {
+	b.logger.Info("starting Bridge")
+
+	// A delivery to a target that isn't accepting events is abandoned when
+	// the Bridge is stopped
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-b.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		for {
+			delivery, ok := b.dequeue()
+
+			if !ok {
+				break
+			}
+
+			b.deliver(ctx, delivery)
+		}
+
+		select {
+		case <-b.signal:
+		case <-b.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver publishes the events of a delivery on the target MessageBus with
+// the context values of the source, bound to the lifetime of ctx
+func (b *Bridge) deliver(ctx context.Context, delivery bridgeDelivery) {
+	deliveryCtx, cancel := context.WithCancel(delivery.ctx)
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	err := b.target.PublishEvents(deliveryCtx, delivery.events...)
+
+	if err != nil {
+		b.logger.Error("forwarding events failed", "error", err.Error())
+	}
+}
+
+// Stop stops delivering forwarded events. Events that have not yet been
+// delivered are discarded, including those waiting for the target to accept
+// them. Stopping a stopped Bridge has no effect.
+func (b *Bridge) Stop() {
+	b.stopOnce.Do(func() {
+		b.logger.Info("stopping Bridge")
+		close(b.done)
+	})
+}
+
+// enqueue queues events for delivery without blocking the source MessageBus.
+// visited holds the MessageBuses the events have passed through.
+func (b *Bridge) enqueue(
+	ctx context.Context,
+	events []messages.Event,
+	visited []*MessageBus,
+) {
+	b.mu.Lock()
+	b.pending = append(b.pending, bridgeDelivery{
+		ctx:    withBridgedEvents(context.WithoutCancel(ctx), events, visited),
+		events: events,
+	})
+	b.mu.Unlock()
+
+	select {
+	case b.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Bridge) dequeue() (bridgeDelivery, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.pending) == 0 {
+		return bridgeDelivery{}, false
+	}
+
+	delivery := b.pending[0]
+	b.pending[0] = bridgeDelivery{}
+	b.pending = b.pending[1:]
+
+	return delivery, true
+}
+
+type bridgedEventsKey struct{}
+
+// bridgedEvent records the MessageBuses that a forwarded event passed through
+type bridgedEvent struct {
+	id      messages.EventID
+	visited []*MessageBus
+}
+
+// withBridgedEvents records that the events delivered with ctx passed
+// through the MessageBuses visited. Only the events themselves are recorded,
+// so that events emitted in reaction to them while they are dispatched
+// aren't mistaken for them.
+func withBridgedEvents(
+	ctx context.Context,
+	events []messages.Event,
+	visited []*MessageBus,
+) context.Context {
+	bridged := make([]bridgedEvent, len(events))
+
+	for i, event := range events {
+		bridged[i] = bridgedEvent{id: event.GetID(), visited: visited}
+	}
+
+	return context.WithValue(ctx, bridgedEventsKey{}, bridged)
+}
+
+// bridgedVia returns the MessageBuses that the event being dispatched with
+// ctx passed through before reaching the MessageBus dispatching it
+func bridgedVia(ctx context.Context, event messages.Event) []*MessageBus {
+	bridged, _ := ctx.Value(bridgedEventsKey{}).([]bridgedEvent)
+
+	for _, b := range bridged {
+		if b.id == event.GetID() {
+			return b.visited
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package messagebus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// Test that a Bridge translates, filters and forwards events between
+// MessageBuses without forwarding them back to where they came from
+func TestBridge(t *testing.T) {
+	orders := messagebus.New()
+	shipping := messagebus.New()
+
+	type PlaceOrderCommand struct {
+		messages.BaseCommand
+		Quantity int
+	}
+
+	type OrderPlacedEvent struct {
+		messages.BaseEvent
+		Quantity int
+	}
+
+	type OrderAcceptedIntegrationEvent struct {
+		messages.BaseEvent
+		Quantity int
+	}
+
+	err := messagebus.RegisterCommandHandler(orders, func(ctx context.Context, cmd *PlaceOrderCommand) ([]messages.Event, error) {
+		evt := &OrderPlacedEvent{Quantity: cmd.Quantity}
+		evt.Init("OrderPlaced")
+		return []messages.Event{evt}, nil
+	})
+	require.NoError(t, err)
+
+	received := make(chan *OrderAcceptedIntegrationEvent, 10)
+	echoed := make(chan *OrderAcceptedIntegrationEvent, 10)
+
+	err = messagebus.RegisterEventHandler(shipping, func(ctx context.Context, evt *OrderAcceptedIntegrationEvent) ([]messages.Event, error) {
+		received <- evt
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(orders, func(ctx context.Context, evt *OrderAcceptedIntegrationEvent) ([]messages.Event, error) {
+		echoed <- evt
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	toShipping := messagebus.NewBridge(orders, shipping)
+
+	err = messagebus.TranslateEvent(toShipping, func(evt *OrderPlacedEvent) ([]messages.Event, error) {
+		if evt.Quantity == 0 {
+			return nil, nil
+		}
+		integrationEvt := &OrderAcceptedIntegrationEvent{Quantity: evt.Quantity}
+		integrationEvt.Init("OrderAccepted")
+		return []messages.Event{integrationEvt}, nil
+	})
+	require.NoError(t, err)
+
+	// Forwarding the integration event back to orders must not loop
+	toOrders := messagebus.NewBridge(shipping, orders)
+
+	err = messagebus.ForwardEvent[*OrderAcceptedIntegrationEvent](toOrders, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go orders.Start(ctx)
+	go shipping.Start(ctx)
+	go toShipping.Start(ctx)
+	go toOrders.Start(ctx)
+
+	err = orders.HandleCommand(context.Background(), &PlaceOrderCommand{Quantity: 0})
+	require.NoError(t, err)
+
+	err = orders.HandleCommand(context.Background(), &PlaceOrderCommand{Quantity: 3})
+	require.NoError(t, err)
+
+	select {
+	case evt := <-received:
+		require.Equal(t, 3, evt.Quantity)
+	case <-time.After(time.Second):
+		t.Fatal("forwarded event was not received")
+	}
+
+	select {
+	case <-received:
+		t.Fatal("filtered event was forwarded")
+	case <-echoed:
+		t.Fatal("forwarded event was forwarded back to its source")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	toShipping.Stop()
+	toOrders.Stop()
+	orders.Stop()
+	shipping.Stop()
+}
+
+// Test that events emitted in reaction to forwarded events are forwarded
+// back across a Bridge, so that replies can be exchanged between MessageBuses
+func TestBridgeReply(t *testing.T) {
+	orders := messagebus.New()
+	shipping := messagebus.New()
+
+	type OrderAcceptedEvent struct {
+		messages.BaseEvent
+	}
+
+	type ShipmentScheduledEvent struct {
+		messages.BaseEvent
+	}
+
+	err := messagebus.RegisterEventHandler(shipping, func(ctx context.Context, evt *OrderAcceptedEvent) ([]messages.Event, error) {
+		reply := &ShipmentScheduledEvent{}
+		reply.Init("ShipmentScheduled")
+		return []messages.Event{reply}, nil
+	})
+	require.NoError(t, err)
+
+	replies := make(chan *ShipmentScheduledEvent, 10)
+	accepted := make(chan *OrderAcceptedEvent, 10)
+
+	err = messagebus.RegisterEventHandler(orders, func(ctx context.Context, evt *ShipmentScheduledEvent) ([]messages.Event, error) {
+		replies <- evt
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(orders, func(ctx context.Context, evt *OrderAcceptedEvent) ([]messages.Event, error) {
+		accepted <- evt
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	toShipping := messagebus.NewBridge(orders, shipping)
+	require.NoError(t, messagebus.ForwardEvent[*OrderAcceptedEvent](toShipping, nil))
+
+	toOrders := messagebus.NewBridge(shipping, orders)
+	require.NoError(t, messagebus.ForwardEvent[*OrderAcceptedEvent](toOrders, nil))
+	require.NoError(t, messagebus.ForwardEvent[*ShipmentScheduledEvent](toOrders, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go orders.Start(ctx)
+	go shipping.Start(ctx)
+	go toShipping.Start(ctx)
+	go toOrders.Start(ctx)
+
+	evt := &OrderAcceptedEvent{}
+	evt.Init("OrderAccepted")
+
+	err = orders.PublishEvents(context.Background(), evt)
+	require.NoError(t, err)
+
+	select {
+	case <-replies:
+	case <-time.After(time.Second):
+		t.Fatal("reply was not forwarded back")
+	}
+
+	// The event is only handled on orders when it is published
+	<-accepted
+
+	select {
+	case <-replies:
+		t.Fatal("reply was forwarded more than once")
+	case <-accepted:
+		t.Fatal("forwarded event was forwarded back to its source")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Stopping a Bridge twice is harmless
+	toShipping.Stop()
+	toShipping.Stop()
+	toOrders.Stop()
+	orders.Stop()
+	shipping.Stop()
+}
+
+// Test that stopping a Bridge abandons a delivery to a target that isn't
+// accepting events, and that delivering to a stopped target fails without
+// stopping the Bridge
+func TestBridgeStop(t *testing.T) {
+	type OrderAcceptedEvent struct {
+		messages.BaseEvent
+	}
+
+	publish := func(mb *messagebus.MessageBus) {
+		evt := &OrderAcceptedEvent{}
+		evt.Init("OrderAccepted")
+		require.NoError(t, mb.PublishEvents(context.Background(), evt))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The target of the Bridge is never started
+	orders := messagebus.New()
+	shipping := messagebus.New()
+
+	bridge := messagebus.NewBridge(orders, shipping)
+	require.NoError(t, messagebus.ForwardEvent[*OrderAcceptedEvent](bridge, nil))
+
+	go orders.Start(ctx)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		bridge.Start(ctx)
+		close(stopped)
+	}()
+
+	publish(orders)
+
+	// Give the Bridge time to block on the delivery
+	time.Sleep(20 * time.Millisecond)
+
+	bridge.Stop()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Bridge blocked on a target that isn't accepting events")
+	}
+
+	orders.Stop()
+
+	// The target of the Bridge has been stopped
+	orders = messagebus.New()
+	shipping = messagebus.New()
+
+	bridge = messagebus.NewBridge(orders, shipping)
+	require.NoError(t, messagebus.ForwardEvent[*OrderAcceptedEvent](bridge, nil))
+
+	go orders.Start(ctx)
+	shipping.Stop()
+
+	err := shipping.PublishEvents(context.Background())
+	require.Equal(t, "messagebus_stopped", dorkyerrors.CodeOf(err))
+
+	go bridge.Start(ctx)
+
+	publish(orders)
+	publish(orders)
+
+	bridge.Stop()
+	orders.Stop()
+}
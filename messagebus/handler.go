@@ -0,0 +1,228 @@
+package messagebus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// ErrHandlerTimeout is returned when a handler doesn't complete within its
+// timeout. It is also the cause of the handler's context being cancelled.
+var ErrHandlerTimeout = dorkyerrors.NewUnavailable(
+	"handler_timeout", "handler timed out",
+)
+
+// HandlerOption configures a command or event handler as it is registered
+type HandlerOption func(*handlerConfig)
+
+type handlerConfig struct {
+	name     string
+	emits    []reflect.Type
+	issues   []reflect.Type
+	timeout  time.Duration
+	parallel bool
+}
+
+// newHandlerConfig returns the configuration of a handler with the name
+// provided
+func newHandlerConfig(opts []HandlerOption, name string) handlerConfig {
+	config := handlerConfig{name: name}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return config
+}
+
+// WithName names the handler in its MessageInfo and the MessageBus's
+// Topology. By default handlers are named after the function implementing
+// them.
+func WithName(name string) HandlerOption {
+	return func(config *handlerConfig) {
+		config.name = name
+	}
+}
+
+// WithEmits declares that the handler emits events of type E, so the flow
+// from the handler to the handlers of E appears in the MessageBus's Topology.
+// It is repeated for each type of event the handler emits.
+func WithEmits[E messages.Event]() HandlerOption {
+	var zero E
+	eventType := reflect.TypeOf(zero)
+
+	return func(config *handlerConfig) {
+		config.emits = append(config.emits, eventType)
+	}
+}
+
+// WithIssues declares that the policy issues commands of type C, so the flow
+// from the policy to the handler of C appears in the MessageBus's Topology.
+// It is repeated for each type of command the policy issues.
+func WithIssues[C messages.Command]() HandlerOption {
+	var zero C
+	commandType := reflect.TypeOf(zero)
+
+	return func(config *handlerConfig) {
+		config.issues = append(config.issues, commandType)
+	}
+}
+
+// WithTimeout limits how long the handler may run, overriding the
+// MessageBus's default handler timeout
+func WithTimeout(timeout time.Duration) HandlerOption {
+	return func(config *handlerConfig) {
+		config.timeout = timeout
+	}
+}
+
+type commandHandler struct {
+	handle func(context.Context, messages.Command) ([]messages.Event, error)
+	handlerConfig
+}
+
+type eventHandler struct {
+	handle func(context.Context, messages.Event) ([]messages.Event, error)
+	handlerConfig
+}
+
+type policyHandler struct {
+	handle func(context.Context, messages.Event) ([]messages.Command, error)
+	handlerConfig
+}
+
+// handlerName returns the name of the function implementing a handler. The
+// suffix the compiler adds to method values is removed so that methods are
+// named as they are declared.
+func handlerName(handler any) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer())
+
+	if fn == nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(fn.Name(), "-fm")
+}
+
+// handlerTimeout returns the timeout that applies to a handler configured
+// with the config provided
+func (mb *MessageBus) handlerTimeout(config handlerConfig) time.Duration {
+	if config.timeout > 0 {
+		return config.timeout
+	}
+	return mb.defaultTimeout
+}
+
+// invokeHandler invokes handle with the message provided. When a timeout
+// applies, the handler's context is cancelled with ErrHandlerTimeout once it
+// expires. Handlers are expected to return once their context is cancelled.
+// The handler always runs to completion on the dispatching goroutine, so
+// messages are still dispatched one at a time when a handler overruns its
+// timeout. A handler that returns after its timeout expired has timed out,
+// and the events it emitted are discarded.
+func invokeHandler[M any](
+	ctx context.Context,
+	timeout time.Duration,
+	handle func(context.Context, M) ([]messages.Event, error),
+	message M,
+) ([]messages.Event, error) {
+	if timeout <= 0 {
+		return handle(ctx, message)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, ErrHandlerTimeout)
+	defer cancel()
+
+	events, err := handle(ctx, message)
+
+	if context.Cause(ctx) != ErrHandlerTimeout {
+		return events, err
+	}
+
+	if err != nil {
+		return nil, handlerTimeoutError(timeout).Wrap(err)
+	}
+
+	return nil, handlerTimeoutError(timeout)
+}
+
+func handlerTimeoutError(timeout time.Duration) *dorkyerrors.Error {
+	err := ErrHandlerTimeout.WithDetail("timeout", timeout)
+	err.Message = fmt.Sprintf("handler timed out after %v", timeout)
+	return err
+}
+
+// handlerStatus returns the status reported to the MetricsHook for a handler
+// that returned the error provided
+func handlerStatus(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrHandlerTimeout):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// trackInFlight returns a context for dispatching the command that is
+// cancelled by CancelCommand. untrackInFlight must be called with the cancel
+// function returned once the command has been dispatched. The context is
+// created for every command with an ID, since any of them may be cancelled,
+// at the cost of two allocations per command.
+func (mb *MessageBus) trackInFlight(
+	ctx context.Context,
+	command messages.Command,
+) (context.Context, context.CancelFunc) {
+	commandID := command.GetID()
+
+	if commandID.IsNil() {
+		return ctx, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	mb.inFlightMu.Lock()
+	mb.inFlight[commandID] = cancel
+	mb.inFlightMu.Unlock()
+
+	return ctx, cancel
+}
+
+func (mb *MessageBus) untrackInFlight(
+	command messages.Command,
+	cancel context.CancelFunc,
+) {
+	if cancel == nil {
+		return
+	}
+
+	mb.inFlightMu.Lock()
+	delete(mb.inFlight, command.GetID())
+	mb.inFlightMu.Unlock()
+
+	cancel()
+}
+
+// CancelCommand cancels the context of the in-flight command with the ID
+// provided. Handlers are expected to return once their context is cancelled.
+// false is returned if no command with the ID is in flight.
+func (mb *MessageBus) CancelCommand(commandID messages.CommandID) bool {
+	mb.inFlightMu.Lock()
+	cancel, ok := mb.inFlight[commandID]
+	mb.inFlightMu.Unlock()
+
+	if ok {
+		mb.logger.Info("cancelling command", "id", commandID)
+		cancel()
+	}
+
+	return ok
+}
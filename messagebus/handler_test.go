@@ -0,0 +1,141 @@
+package messagebus_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// recordingMetrics records the status of each handler invocation
+type recordingMetrics struct {
+	mu       sync.Mutex
+	statuses map[string]string
+}
+
+func (m *recordingMetrics) ObserveCommand(commandType string, status string, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statuses[commandType] = status
+}
+
+func (m *recordingMetrics) ObserveEvent(eventType string, status string, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statuses[eventType] = status
+}
+
+// Test that handlers exceeding their timeouts are reported once they return
+func TestHandlerTimeouts(t *testing.T) {
+	metrics := &recordingMetrics{statuses: make(map[string]string)}
+
+	mb := messagebus.New(
+		messagebus.WithMetricsHook(metrics),
+		messagebus.WithDefaultHandlerTimeout(20*time.Millisecond),
+	)
+
+	type OverrunningCommand struct {
+		messages.BaseCommand
+	}
+
+	type SlowCommand struct {
+		messages.BaseCommand
+	}
+
+	type SlowEvent struct {
+		messages.BaseEvent
+	}
+
+	var overrunFinished bool
+
+	// Ignores its context and returns after its timeout
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *OverrunningCommand) ([]messages.Event, error) {
+		time.Sleep(30 * time.Millisecond)
+		overrunFinished = true
+		return nil, nil
+	}, messagebus.WithTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+
+	// Completes within its own timeout, which overrides the default
+	err = messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *SlowCommand) ([]messages.Event, error) {
+		time.Sleep(40 * time.Millisecond)
+		evt := &SlowEvent{}
+		evt.Init("SlowEvent")
+		return []messages.Event{evt}, nil
+	}, messagebus.WithTimeout(time.Second))
+	require.NoError(t, err)
+
+	// Honours its context, which is cancelled by the default timeout
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *SlowEvent) ([]messages.Event, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	require.NoError(t, err)
+
+	go mb.Start(context.Background())
+
+	overrunning := &OverrunningCommand{}
+	overrunning.Init("Overrunning")
+
+	err = mb.HandleCommand(context.Background(), overrunning)
+	require.ErrorIs(t, err, messagebus.ErrHandlerTimeout)
+
+	// The MessageBus waits for the handler rather than abandoning it
+	require.True(t, overrunFinished)
+
+	slow := &SlowCommand{}
+	slow.Init("Slow")
+
+	err = mb.HandleCommand(context.Background(), slow)
+	require.NoError(t, err)
+
+	mb.Stop()
+
+	require.Equal(t, map[string]string{
+		"Overrunning": "timeout",
+		"Slow":        "success",
+		"SlowEvent":   "timeout",
+	}, metrics.statuses)
+}
+
+// Test that an in-flight command can be cancelled by its ID
+func TestCancelCommand(t *testing.T) {
+	mb := messagebus.New()
+
+	type LongCommand struct {
+		messages.BaseCommand
+	}
+
+	started := make(chan struct{})
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *LongCommand) ([]messages.Event, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	require.NoError(t, err)
+
+	go mb.Start(context.Background())
+
+	cmd := &LongCommand{}
+	cmd.Init("Long")
+
+	require.False(t, mb.CancelCommand(cmd.ID))
+
+	go func() {
+		<-started
+		mb.CancelCommand(cmd.ID)
+	}()
+
+	err = mb.HandleCommand(context.Background(), cmd)
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.False(t, mb.CancelCommand(cmd.ID))
+
+	mb.Stop()
+}
@@ -0,0 +1,125 @@
+package messagebus
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/dmpettyp/dorky/id"
+)
+
+// MessageInfo describes the message a handler is being invoked with, and
+// where it sits in the chain of messages that caused it
+type MessageInfo struct {
+	// MessageID is the ID of the command or event being handled
+	MessageID id.ID
+
+	// MessageType is the type of the command or event being handled
+	MessageType string
+
+	// RootCommandID is the ID of the command that started the chain of
+	// messages, which is also the chain's correlation ID. Chains started by
+	// events published with PublishEvents are rooted at the published event.
+	RootCommandID id.ID
+
+	// Depth is the number of messages between the message and the root of its
+	// chain. Commands handled with HandleCommand and published events have a
+	// depth of 0, the events they cause have a depth of 1, and so on,
+	// including through commands issued by policies.
+	Depth int
+
+	// Handler is the name of the handler being invoked
+	Handler string
+
+	logger *slog.Logger
+}
+
+type messageInfoKey struct{}
+
+// messageInfoContext carries a handler's MessageInfo. It stores the
+// MessageInfo in place rather than using context.WithValue so that the
+// contexts of an event's handlers can be allocated together.
+type messageInfoContext struct {
+	context.Context
+	info MessageInfo
+}
+
+func (c *messageInfoContext) Value(key any) any {
+	if key == (messageInfoKey{}) {
+		return &c.info
+	}
+	return c.Context.Value(key)
+}
+
+// MessageInfoFromContext returns the MessageInfo the MessageBus attaches to
+// the context of every handler and policy it invokes. false is returned when
+// ctx isn't a handler's context.
+func MessageInfoFromContext(ctx context.Context) (MessageInfo, bool) {
+	info, ok := ctx.Value(messageInfoKey{}).(*MessageInfo)
+
+	if !ok {
+		return MessageInfo{}, false
+	}
+
+	return *info, true
+}
+
+// Logger returns the MessageBus's logger with attributes identifying the
+// message and handler. The default logger is used for a MessageInfo that
+// wasn't returned by MessageInfoFromContext.
+func (info MessageInfo) Logger() *slog.Logger {
+	logger := info.logger
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return logger.With(
+		slog.String("message_id", info.MessageID.String()),
+		slog.String("message_type", info.MessageType),
+		slog.String("root_command_id", info.RootCommandID.String()),
+		slog.Int("depth", info.Depth),
+		slog.String("handler", info.Handler),
+	)
+}
+
+func (mb *MessageBus) withMessageInfo(
+	ctx context.Context,
+	info MessageInfo,
+) context.Context {
+	info.logger = mb.logger
+	return &messageInfoContext{Context: ctx, info: info}
+}
+
+// eventContexts returns the contexts of the handlers of a route, followed by
+// those of its policies, carrying their MessageInfo for the queued event. They
+// are allocated together so that dispatching an event takes a single
+// allocation however many handlers it has.
+func (mb *MessageBus) eventContexts(
+	ctx context.Context,
+	queued queuedEvent,
+	route eventRoute,
+) []messageInfoContext {
+	event := queued.event
+
+	info := MessageInfo{
+		MessageID:     event.GetID().ID,
+		MessageType:   event.GetType(),
+		RootCommandID: correlationID(event.GetID().ID, event.GetCorrelationID()),
+		Depth:         queued.depth,
+		logger:        mb.logger,
+	}
+
+	contexts := make([]messageInfoContext, len(route.handlers)+len(route.policies))
+
+	for i := range contexts {
+		contexts[i] = messageInfoContext{Context: ctx, info: info}
+
+		if i < len(route.handlers) {
+			contexts[i].info.Handler = route.handlers[i].name
+		} else {
+			contexts[i].info.Handler = route.policies[i-len(route.handlers)].name
+		}
+	}
+
+	return contexts
+}
@@ -0,0 +1,142 @@
+package messagebus_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+type shipmentRequestedEvent struct {
+	messages.BaseEvent
+}
+
+type shipmentDispatchedEvent struct {
+	messages.BaseEvent
+}
+
+// Test that handlers can tell which message and chain they are handling
+func TestMessageInfoFromContext(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	mb := messagebus.New(
+		messagebus.WithInlineDispatch(),
+		messagebus.WithLogger(logger),
+	)
+
+	infos := make(map[string]messagebus.MessageInfo)
+
+	record := func(ctx context.Context) {
+		info, ok := messagebus.MessageInfoFromContext(ctx)
+		require.True(t, ok)
+		infos[info.MessageType] = info
+	}
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *CreateOrderCommand) ([]messages.Event, error) {
+		record(ctx)
+		evt := &OrderCreatedEvent{OrderID: cmd.OrderID}
+		evt.Init("OrderCreated")
+		return []messages.Event{evt}, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterPolicy(mb, func(ctx context.Context, evt *OrderCreatedEvent) ([]messages.Command, error) {
+		record(ctx)
+		cmd := &ShipOrderCommand{OrderID: evt.OrderID}
+		cmd.Init("ShipOrder")
+		return []messages.Command{cmd}, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *ShipOrderCommand) ([]messages.Event, error) {
+		record(ctx)
+		evt := &OrderShippedEvent{OrderID: cmd.OrderID}
+		evt.Init("OrderShipped")
+		return []messages.Event{evt}, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *OrderShippedEvent) ([]messages.Event, error) {
+		info, _ := messagebus.MessageInfoFromContext(ctx)
+		info.Logger().Info("order shipped")
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	cmd := &CreateOrderCommand{OrderID: "123"}
+	cmd.Init("CreateOrder")
+
+	err = mb.HandleCommand(context.Background(), cmd)
+	require.NoError(t, err)
+
+	require.Len(t, infos, 3)
+
+	for _, info := range infos {
+		require.Equal(t, cmd.ID.ID, info.RootCommandID)
+	}
+
+	require.Equal(t, cmd.ID.ID, infos["CreateOrder"].MessageID)
+	require.Equal(t, 0, infos["CreateOrder"].Depth)
+	require.Contains(t, infos["CreateOrder"].Handler, "TestMessageInfoFromContext.func")
+	require.Equal(t, 1, infos["OrderCreated"].Depth)
+	require.Equal(t, 2, infos["ShipOrder"].Depth)
+
+	require.Contains(t, logs.String(), "msg=\"order shipped\"")
+	require.Contains(t, logs.String(), "message_type=OrderShipped")
+	require.Contains(t, logs.String(), "root_command_id="+cmd.ID.String())
+	require.Contains(t, logs.String(), "depth=3")
+
+	_, ok := messagebus.MessageInfoFromContext(context.Background())
+	require.False(t, ok)
+}
+
+// Test that handlers registered as methods are named after the method
+func TestMessageInfoHandlerName(t *testing.T) {
+	mb := messagebus.New(messagebus.WithInlineDispatch())
+
+	var handler string
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *CreateOrderCommand) ([]messages.Event, error) {
+		return []messages.Event{&shipmentRequestedEvent{}}, nil
+	})
+	require.NoError(t, err)
+
+	svc := &shipmentService{handler: &handler}
+	require.NoError(t, messagebus.RegisterEventHandler(mb, svc.OnShipmentRequested))
+	require.NoError(t, messagebus.RegisterHandlers(mb, &dispatchService{handler: &handler}))
+
+	err = mb.HandleCommand(context.Background(), &CreateOrderCommand{})
+	require.NoError(t, err)
+
+	require.Equal(t, "*messagebus_test.dispatchService.OnShipmentDispatched", handler)
+}
+
+type shipmentService struct {
+	handler *string
+}
+
+func (s *shipmentService) OnShipmentRequested(ctx context.Context, evt *shipmentRequestedEvent) ([]messages.Event, error) {
+	info, _ := messagebus.MessageInfoFromContext(ctx)
+
+	if info.Handler != "github.com/dmpettyp/dorky/messagebus_test.(*shipmentService).OnShipmentRequested" {
+		return nil, nil
+	}
+
+	return []messages.Event{&shipmentDispatchedEvent{}}, nil
+}
+
+type dispatchService struct {
+	handler *string
+}
+
+func (s *dispatchService) OnShipmentDispatched(ctx context.Context, evt *shipmentDispatchedEvent) ([]messages.Event, error) {
+	info, _ := messagebus.MessageInfoFromContext(ctx)
+	*s.handler = info.Handler
+	return nil, nil
+}
@@ -7,10 +7,13 @@ import (
 	"io"
 	"log/slog"
 	"reflect"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/id"
 	"github.com/dmpettyp/dorky/messages"
 )
 
@@ -21,21 +24,72 @@ import (
 type MessageBus struct {
 	started         atomic.Bool
 	commands        chan messageBusCommand
-	eventHandlers   map[reflect.Type][]func(context.Context, messages.Event) ([]messages.Event, error)
-	commandHandlers map[reflect.Type]func(context.Context, messages.Command) ([]messages.Event, error)
-	eventsToProcess *Queue[messages.Event]
-	wg              sync.WaitGroup
-	logger          *slog.Logger
-	metrics         MetricsHook
+	eventHandlers   map[reflect.Type][]eventHandler
+	commandHandlers map[reflect.Type]commandHandler
+	policies        map[reflect.Type][]policyHandler
+	eventsToProcess *Queue[queuedEvent]
+	// commandsToProcess holds commands issued by policies that are processed
+	// once the cascade of events currently being dispatched completes
+	commandsToProcess *Queue[messageBusCommand]
+	wg                sync.WaitGroup
+	logger            *slog.Logger
+	metrics           MetricsHook
+	queueMetrics      QueueMetricsHook
+	commandsWaiting   atomic.Int64
+	defaultTimeout    time.Duration
+	audit             AuditSink
+
+	// auditRecord is the audit record of the command whose cascade is being
+	// dispatched
+	auditRecord *AuditRecord
+	auditStart  time.Time
+
+	// inFlight holds the functions that cancel commands being dispatched
+	inFlightMu sync.Mutex
+	inFlight   map[messages.CommandID]context.CancelFunc
+
+	// eventRoutes is the table of event handlers and policies for each Event
+	// type, built once when the MessageBus starts dispatching so each event
+	// is routed with a single lookup
+	eventRoutes     map[reflect.Type]eventRoute
+	eventRoutesOnce sync.Once
+
+	// parallelEvents holds the concurrency limit of Event types whose
+	// handlers all run in parallel
+	parallelEvents map[reflect.Type]int
+
+	// parallelLimit is the number of handlers of an event that run in
+	// parallel at once
+	parallelLimit int
+
+	subscribersMu   sync.RWMutex
+	subscribers     []*subscriber
+	subscriberCount atomic.Int64
+
+	// inline MessageBuses dispatch commands on the caller's goroutine, with
+	// inlineMu serializing dispatches in place of the Start loop
+	inline   bool
+	inlineMu sync.Mutex
+	stopped  bool
+
+	// done is closed when the MessageBus is stopped, after which no more
+	// commands are accepted
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
 func New(opts ...Option) *MessageBus {
 	mb := &MessageBus{
-		commands:        make(chan messageBusCommand),
-		eventHandlers:   make(map[reflect.Type][]func(context.Context, messages.Event) ([]messages.Event, error)),
-		commandHandlers: make(map[reflect.Type]func(context.Context, messages.Command) ([]messages.Event, error)),
-		eventsToProcess: NewQueue[messages.Event](),
-		logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		commands:          make(chan messageBusCommand),
+		done:              make(chan struct{}),
+		eventHandlers:     make(map[reflect.Type][]eventHandler),
+		commandHandlers:   make(map[reflect.Type]commandHandler),
+		policies:          make(map[reflect.Type][]policyHandler),
+		eventsToProcess:   NewQueue[queuedEvent](),
+		commandsToProcess: NewQueue[messageBusCommand](),
+		logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+		inFlight:          make(map[messages.CommandID]context.CancelFunc),
+		parallelLimit:     defaultParallelLimit,
 	}
 
 	for _, opt := range opts {
@@ -59,13 +113,17 @@ func New(opts ...Option) *MessageBus {
 func RegisterCommandHandler[C messages.Command](
 	mb *MessageBus,
 	handler func(context.Context, C) ([]messages.Event, error),
+	opts ...HandlerOption,
 ) error {
 	var zero C
 
 	return mb.registerCommandHandler(
 		reflect.TypeOf(zero),
-		func(ctx context.Context, cmd messages.Command) ([]messages.Event, error) {
-			return handler(ctx, cmd.(C))
+		commandHandler{
+			handle: func(ctx context.Context, cmd messages.Command) ([]messages.Event, error) {
+				return handler(ctx, cmd.(C))
+			},
+			handlerConfig: newHandlerConfig(opts, handlerName(handler)),
 		},
 	)
 }
@@ -76,24 +134,103 @@ func RegisterCommandHandler[C messages.Command](
 func RegisterEventHandler[E messages.Event](
 	mb *MessageBus,
 	handler func(context.Context, E) ([]messages.Event, error),
+	opts ...HandlerOption,
 ) error {
 	var zero E
 
 	return mb.registerEventHandler(
 		reflect.TypeOf(zero),
-		func(ctx context.Context, evt messages.Event) ([]messages.Event, error) {
-			return handler(ctx, evt.(E))
+		eventHandler{
+			handle: func(ctx context.Context, evt messages.Event) ([]messages.Event, error) {
+				return handler(ctx, evt.(E))
+			},
+			handlerConfig: newHandlerConfig(opts, handlerName(handler)),
 		},
 	)
 }
 
+// RegisterPolicy registers a type-safe policy with the MessageBus. A policy
+// reacts to an Event by issuing follow-up Commands. The Commands are queued
+// and processed once the cascade of events currently being dispatched
+// completes, and are linked to the Event that caused them.
+//
+// Policies are named and described in the MessageBus's Topology with
+// WithName and WithIssues. Other HandlerOptions don't apply to policies.
+func RegisterPolicy[E messages.Event](
+	mb *MessageBus,
+	policy func(context.Context, E) ([]messages.Command, error),
+	opts ...HandlerOption,
+) error {
+	var zero E
+
+	return mb.registerPolicy(
+		reflect.TypeOf(zero),
+		policyHandler{
+			handle: func(ctx context.Context, evt messages.Event) ([]messages.Command, error) {
+				return policy(ctx, evt.(E))
+			},
+			handlerConfig: newHandlerConfig(opts, handlerName(policy)),
+		},
+	)
+}
+
+// eventRoute holds the event handlers and policies registered for an Event
+// type
+type eventRoute struct {
+	handlers []eventHandler
+	policies []policyHandler
+
+	// parallel is set when any of the handlers run in parallel, with at most
+	// limit running at once when limit is positive, and the MessageBus's
+	// parallel limit otherwise
+	parallel bool
+	limit    int
+}
+
+// resultChannels pools the channels used to return dispatch results to
+// callers of HandleCommand and PublishEvents
+var resultChannels = sync.Pool{
+	New: func() any { return make(chan error) },
+}
+
+// messageBusCommand is a unit of work submitted to the MessageBus. It carries
+// either a command to be dispatched or events published from outside of the
+// MessageBus.
 type messageBusCommand struct {
-	command messages.Command
-	ctx     context.Context
-	result  chan error
+	command   messages.Command
+	events    []messages.Event
+	ctx       context.Context
+	result    chan error
+	submitted time.Time
+
+	// depth is the depth in its cascade of a command issued by a policy
+	depth int
+
+	// actor is the actor that sent the command or events, or the command or
+	// events at the root of the cascade of a command issued by a policy
+	actor string
+}
+
+// queuedEvent is an event queued to be dispatched along with its depth in
+// the cascade of messages that caused it
+type queuedEvent struct {
+	event messages.Event
+	depth int
+}
+
+func (c messageBusCommand) description() string {
+	if c.command == nil {
+		return "events"
+	}
+	return "a command"
 }
 
 func (mb *MessageBus) Start(ctx context.Context) {
+	if mb.inline {
+		mb.logger.Error("MessageBus dispatches inline and is not started")
+		return
+	}
+
 	if !mb.started.CompareAndSwap(false, true) {
 		mb.logger.Error("MessageBus already started")
 		return
@@ -105,31 +242,52 @@ func (mb *MessageBus) Start(ctx context.Context) {
 
 	mb.logger.Info("starting MessageBus")
 
+	mb.eventRoutesOnce.Do(mb.buildEventRoutes)
+
 	for {
 		select {
-		case c, ok := <-mb.commands:
-			if !ok {
-				// Channel closed, shutdown
-				return
-			}
-
-			err := mb.dispatchCommand(c.ctx, c.command)
+		case c := <-mb.commands:
+			err := mb.dispatchSubmitted(c.ctx, c)
 
 			select {
 			case c.result <- err:
 			case <-c.ctx.Done():
 			}
 
-			mb.dispatchEvents(ctx)
+			cascadeCtx := ctx
+
+			// Published events are dispatched with the publisher's context
+			// values, but not bound to its lifetime
+			if c.command == nil {
+				cascadeCtx = withoutCancel(c.ctx)
+			}
+
+			mb.dispatchCascade(cascadeCtx, c)
+		case <-mb.done:
+			return
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// Stop stops the MessageBus once the command being dispatched and its cascade
+// of events have been dispatched. Commands and events submitted once the
+// MessageBus is stopped fail with messagebus_stopped. Stopping a stopped
+// MessageBus has no effect.
 func (mb *MessageBus) Stop() {
 	mb.logger.Info("stopping MessageBus")
-	close(mb.commands)
+
+	if mb.inline {
+		mb.inlineMu.Lock()
+		mb.stopped = true
+		mb.inlineMu.Unlock()
+		return
+	}
+
+	mb.stopOnce.Do(func() {
+		close(mb.done)
+	})
 	mb.wg.Wait()
 }
 
@@ -137,39 +295,136 @@ func (mb *MessageBus) HandleCommand(
 	ctx context.Context,
 	command messages.Command,
 ) error {
-	resultChannel := make(chan error)
-	defer func() { close(resultChannel) }()
+	return mb.submit(ctx, messageBusCommand{
+		command:   command,
+		ctx:       ctx,
+		submitted: time.Now(),
+		actor:     ActorFromContext(ctx),
+	})
+}
+
+// PublishEvents submits events raised outside of the MessageBus to be
+// dispatched to the MessageBus's event handlers. PublishEvents returns once
+// the events have been accepted, without waiting for them to be dispatched.
+func (mb *MessageBus) PublishEvents(
+	ctx context.Context,
+	events ...messages.Event,
+) error {
+	return mb.submit(ctx, messageBusCommand{
+		events:    events,
+		ctx:       ctx,
+		submitted: time.Now(),
+		actor:     ActorFromContext(ctx),
+	})
+}
+
+// submit hands the unit of work to the MessageBus and waits for the result of
+// dispatching it
+func (mb *MessageBus) submit(ctx context.Context, c messageBusCommand) error {
+	if mb.inline {
+		return mb.submitInline(ctx, c)
+	}
+
+	resultChannel := resultChannels.Get().(chan error)
+
+	c.result = resultChannel
+
+	mb.setCommandsWaiting(mb.commandsWaiting.Add(1))
 
 	select {
-	case mb.commands <- messageBusCommand{command, ctx, resultChannel}:
+	case mb.commands <- c:
+		mb.setCommandsWaiting(mb.commandsWaiting.Add(-1))
+	case <-mb.done:
+		mb.setCommandsWaiting(mb.commandsWaiting.Add(-1))
+		return mb.stoppedError(c)
 	case <-ctx.Done():
-		return fmt.Errorf(
-			"cannot send a command to the messagebus to handle: %w", ctx.Err(),
+		mb.setCommandsWaiting(mb.commandsWaiting.Add(-1))
+		return dorkyerrors.Wrap(
+			ctx.Err(),
+			dorkyerrors.Unavailable,
+			"messagebus_unavailable",
+			fmt.Sprintf("cannot send %s to the messagebus to handle", c.description()),
 		)
 	}
 
 	select {
 	case result := <-resultChannel:
+		// The channel is only reused once the MessageBus is done with it
+		resultChannels.Put(resultChannel)
 		return result
 	case <-ctx.Done():
-		return fmt.Errorf(
-			"cannot receive messagebus handle response: %w", ctx.Err(),
+		return dorkyerrors.Wrap(
+			ctx.Err(),
+			dorkyerrors.Unavailable,
+			"messagebus_unavailable",
+			"cannot receive messagebus handle response",
+		)
+	}
+}
+
+// submitInline dispatches the unit of work and its cascade of events on the
+// caller's goroutine. Dispatches are serialized so handlers observe the same
+// guarantees as they do when the MessageBus is started.
+func (mb *MessageBus) submitInline(
+	ctx context.Context,
+	c messageBusCommand,
+) error {
+	if err := ctx.Err(); err != nil {
+		return dorkyerrors.Wrap(
+			err,
+			dorkyerrors.Unavailable,
+			"messagebus_unavailable",
+			fmt.Sprintf("cannot send %s to the messagebus to handle", c.description()),
 		)
 	}
+
+	mb.setCommandsWaiting(mb.commandsWaiting.Add(1))
+	mb.inlineMu.Lock()
+	defer mb.inlineMu.Unlock()
+	mb.setCommandsWaiting(mb.commandsWaiting.Add(-1))
+
+	if mb.stopped {
+		return mb.stoppedError(c)
+	}
+
+	// Handlers can no longer be registered once the first command is handled
+	mb.started.Store(true)
+	mb.eventRoutesOnce.Do(mb.buildEventRoutes)
+
+	err := mb.dispatchSubmitted(ctx, c)
+
+	// As when started, the cascade of events isn't bound to the lifetime of
+	// the caller's context
+	mb.dispatchCascade(withoutCancel(ctx), c)
+
+	return err
+}
+
+func (mb *MessageBus) stoppedError(c messageBusCommand) error {
+	return dorkyerrors.NewUnavailable(
+		"messagebus_stopped",
+		fmt.Sprintf("cannot handle %s, MessageBus has been stopped", c.description()),
+	)
 }
 
 // registerCommandHandler registers a type safe handler for the commandType
 // provided. Only one handler may be registered for each commandType
 func (mb *MessageBus) registerCommandHandler(
 	commandType reflect.Type,
-	handler func(context.Context, messages.Command) ([]messages.Event, error),
+	handler commandHandler,
 ) error {
 	if mb.started.Load() {
-		return fmt.Errorf("cannot register handlers after MessageBus has started")
+		return dorkyerrors.NewConflict(
+			"messagebus_started",
+			"cannot register handlers after MessageBus has started",
+		)
 	}
 
 	if _, exists := mb.commandHandlers[commandType]; exists {
-		return fmt.Errorf("handler already registered for command type %v", commandType)
+		return dorkyerrors.NewConflict(
+			"handler_already_registered",
+			fmt.Sprintf("handler already registered for command type %v", commandType),
+		).WithDetail("type", commandType.String())
 	}
 
 	mb.commandHandlers[commandType] = handler
@@ -183,10 +438,13 @@ func (mb *MessageBus) registerCommandHandler(
 // provided. Many handler may be registered for each Event type
 func (mb *MessageBus) registerEventHandler(
 	eventType reflect.Type,
-	handler func(context.Context, messages.Event) ([]messages.Event, error),
+	handler eventHandler,
 ) error {
 	if mb.started.Load() {
-		return fmt.Errorf("cannot register event handler after MessageBus has started")
+		return dorkyerrors.NewConflict(
+			"messagebus_started",
+			"cannot register event handler after MessageBus has started",
+		)
 	}
 
 	mb.eventHandlers[eventType] = append(
@@ -199,26 +457,155 @@ func (mb *MessageBus) registerEventHandler(
 	return nil
 }
 
+// registerPolicy registers a type safe policy for the Event type provided.
+// Many policies may be registered for each Event type
+func (mb *MessageBus) registerPolicy(
+	eventType reflect.Type,
+	policy policyHandler,
+) error {
+	if mb.started.Load() {
+		return dorkyerrors.NewConflict(
+			"messagebus_started",
+			"cannot register policy after MessageBus has started",
+		)
+	}
+
+	mb.policies[eventType] = append(mb.policies[eventType], policy)
+
+	mb.logger.Info("registered policy", "type", eventType)
+
+	return nil
+}
+
+// buildEventRoutes builds the table used to route events to their handlers
+// and policies from the handlers and policies registered
+func (mb *MessageBus) buildEventRoutes() {
+	mb.eventRoutes = make(map[reflect.Type]eventRoute)
+
+	for eventType, handlers := range mb.eventHandlers {
+		route := mb.eventRoutes[eventType]
+		route.handlers = handlers
+
+		if limit, ok := mb.parallelEvents[eventType]; ok {
+			route.handlers = make([]eventHandler, len(handlers))
+
+			for i, handler := range handlers {
+				handler.parallel = true
+				route.handlers[i] = handler
+			}
+
+			route.limit = limit
+		}
+
+		route.parallel = slices.ContainsFunc(
+			route.handlers,
+			func(handler eventHandler) bool { return handler.parallel },
+		)
+
+		mb.eventRoutes[eventType] = route
+	}
+
+	for eventType, policies := range mb.policies {
+		route := mb.eventRoutes[eventType]
+		route.policies = policies
+		mb.eventRoutes[eventType] = route
+	}
+}
+
+// dispatchSubmitted dispatches a submitted command, or queues submitted
+// events to be dispatched as part of the cascade that follows
+func (mb *MessageBus) dispatchSubmitted(
+	ctx context.Context,
+	c messageBusCommand,
+) error {
+	if c.command != nil {
+		return mb.dispatchCommand(ctx, c)
+	}
+
+	mb.queueEvents(c.events, 0)
+
+	return nil
+}
+
+// dispatchCascade dispatches the events queued by a command, followed by any
+// commands issued by policies in reaction to those events, until there is no
+// more work queued.
+func (mb *MessageBus) dispatchCascade(ctx context.Context, c messageBusCommand) {
+	mb.observeCommandCascade(c, mb.dispatchEvents(ctx, c.actor))
+	mb.writeAudit(ctx)
+
+	for {
+		queued, ok := mb.commandsToProcess.dequeue()
+
+		if !ok {
+			return
+		}
+
+		// Errors are logged by dispatchCommand, there is no caller to return
+		// them to for commands issued by policies
+		_ = mb.dispatchCommand(ctx, queued)
+
+		mb.observeCommandCascade(queued, mb.dispatchEvents(ctx, queued.actor))
+		mb.writeAudit(ctx)
+	}
+}
+
 // dispatchCommand invokes the command handler for the type of Command
-// passed in. Events generated from invoking the handler are queued and
-// dispatched to event handlers after the command handler returns.
-func (mb *MessageBus) dispatchCommand(ctx context.Context, command messages.Command) error {
-	mb.logger.Info("messagebus dispatching command", "type", command.GetType())
+// passed in, recording it in the audit log if one is configured. Events
+// generated from invoking the handler are queued and dispatched to event
+// handlers after the command handler returns.
+func (mb *MessageBus) dispatchCommand(
+	ctx context.Context,
+	c messageBusCommand,
+) error {
+	mb.beginAudit(c.command, c.actor)
+
+	err := mb.invokeCommandHandler(ctx, c.command, c.depth)
+
+	mb.completeAudit(err)
+
+	return err
+}
+
+// invokeCommandHandler invokes the command handler for the type of Command
+// passed in and queues the events it generates
+func (mb *MessageBus) invokeCommandHandler(
+	ctx context.Context,
+	command messages.Command,
+	depth int,
+) error {
+	mb.logger.LogAttrs(ctx, slog.LevelInfo, "messagebus dispatching command",
+		slog.String("type", command.GetType()))
 
-	commandJSON, err := json.Marshal(command)
-	mb.logger.Debug("messagebus dispatching command", "command", string(commandJSON))
+	mb.logMessageJSON(ctx, "messagebus dispatching command", "command", command)
 
 	commandType := reflect.TypeOf(command)
 
 	handler, ok := mb.commandHandlers[commandType]
 
 	if !ok {
-		mb.logger.Info("no command handler found")
-		return fmt.Errorf("no handler for command type %v", commandType)
+		mb.logger.LogAttrs(ctx, slog.LevelInfo, "no command handler found")
+		return dorkyerrors.NewInternal(
+			"no_command_handler",
+			fmt.Sprintf("no handler for command type %v", commandType),
+		).WithDetail("type", commandType.String())
 	}
 
+	ctx, cancel := mb.trackInFlight(ctx, command)
+	defer mb.untrackInFlight(command, cancel)
+
+	ctx = mb.withMessageInfo(ctx, MessageInfo{
+		MessageID:     command.GetID().ID,
+		MessageType:   command.GetType(),
+		RootCommandID: correlationID(command.GetID().ID, command.GetCorrelationID()),
+		Depth:         depth,
+		Handler:       handler.name,
+	})
+
 	start := time.Now()
-	events, err := handler(ctx, command)
+	events, err := invokeHandler(
+		ctx, mb.handlerTimeout(handler.handlerConfig), handler.handle, command,
+	)
 	mb.observeCommandHandler(command, err, start)
 
 	if err != nil {
@@ -226,63 +613,219 @@ func (mb *MessageBus) dispatchCommand(ctx context.Context, command messages.Comm
 		return err
 	}
 
-	mb.eventsToProcess.enqueueMultiple(events)
+	linkEvents(
+		events,
+		command.GetID().ID,
+		correlationID(command.GetID().ID, command.GetCorrelationID()),
+	)
+
+	mb.queueEvents(events, depth+1)
 
 	return nil
 }
 
 // dispatchEvents dispatches all events queued up by the MessageBus to any
 // handlers that are registered for them. Events returned by the event
-// handlers are queued up and processed before returning.
-func (mb *MessageBus) dispatchEvents(ctx context.Context) {
+// handlers are queued up and processed before returning. The number of
+// events dispatched is returned. Commands issued by policies are queued with
+// the actor provided, which is the actor of the cascade.
+func (mb *MessageBus) dispatchEvents(ctx context.Context, actor string) int {
+	dispatched := 0
+
 	for {
-		event, ok := mb.eventsToProcess.dequeue()
+		queued, ok := mb.eventsToProcess.dequeue()
 
 		if !ok {
-			return
+			return dispatched
 		}
 
-		mb.logger.Info("messagebus dispatching event", "type", event.GetType())
+		event := queued.event
+
+		dispatched++
+		mb.setEventQueueDepth()
+
+		mb.logger.LogAttrs(ctx, slog.LevelInfo, "messagebus dispatching event",
+			slog.String("type", event.GetType()))
 
-		eventJSON, _ := json.Marshal(event)
-		mb.logger.Debug("messagebus dispatching event", "event", string(eventJSON))
+		mb.logMessageJSON(ctx, "messagebus dispatching event", "event", event)
 
-		eventType := reflect.TypeOf(event)
+		mb.notifySubscribers(ctx, event)
+		mb.auditEvent(event)
 
-		if handlers, ok := mb.eventHandlers[eventType]; ok {
-			for _, handler := range handlers {
-				start := time.Now()
-				events, err := handler(ctx, event)
-				mb.observeEventHandler(event, err, start)
+		route, ok := mb.eventRoutes[reflect.TypeOf(event)]
+
+		if !ok {
+			continue
+		}
+
+		contexts := mb.eventContexts(ctx, queued, route)
+
+		if route.parallel {
+			mb.invokeEventHandlersInParallel(contexts, queued, route)
+		} else {
+			for i, handler := range route.handlers {
+				events, err := mb.invokeEventHandler(&contexts[i], queued, handler)
 
 				if err != nil {
 					mb.logger.Error("invoking event handler failed", "error", err.Error())
 				}
 
-				mb.eventsToProcess.enqueueMultiple(events)
+				mb.enqueueEvents(queued, events)
+			}
+		}
+
+		for i, policy := range route.policies {
+			commands, err := policy.handle(&contexts[len(route.handlers)+i], event)
+
+			if err != nil {
+				mb.logger.Error("invoking policy failed", "error", err.Error())
+				continue
+			}
+
+			for _, command := range commands {
+				if command.GetCausationID().IsNil() {
+					command.SetCausation(
+						event.GetID().ID,
+						correlationID(event.GetID().ID, event.GetCorrelationID()),
+					)
+				}
+
+				mb.commandsToProcess.enqueue(messageBusCommand{
+					command:   command,
+					submitted: time.Now(),
+					depth:     queued.depth + 1,
+					actor:     actor,
+				})
 			}
 		}
 	}
 }
 
-func (mb *MessageBus) observeCommandHandler(command messages.Command, err error, start time.Time) {
-	if mb.metrics == nil {
+// invokeEventHandler invokes an event handler with its context, reporting it
+// to the MetricsHook
+func (mb *MessageBus) invokeEventHandler(
+	ctx context.Context,
+	queued queuedEvent,
+	handler eventHandler,
+) ([]messages.Event, error) {
+	start := time.Now()
+	events, err := invokeHandler(
+		ctx, mb.handlerTimeout(handler.handlerConfig), handler.handle, queued.event,
+	)
+	mb.observeEventHandler(queued.event, err, start)
+
+	return events, err
+}
+
+// enqueueEvents queues the events emitted by a handler of the queued event
+// provided to be dispatched
+func (mb *MessageBus) enqueueEvents(queued queuedEvent, events []messages.Event) {
+	event := queued.event
+
+	linkEvents(
+		events,
+		event.GetID().ID,
+		correlationID(event.GetID().ID, event.GetCorrelationID()),
+	)
+
+	mb.queueEvents(events, queued.depth+1)
+}
+
+// queueEvents queues events at the depth provided to be dispatched
+func (mb *MessageBus) queueEvents(events []messages.Event, depth int) {
+	for _, event := range events {
+		mb.eventsToProcess.enqueue(queuedEvent{event: event, depth: depth})
+	}
+
+	mb.setEventQueueDepth()
+}
+
+// withoutCancel returns a context with the values of ctx that isn't cancelled
+// when ctx is. Contexts that can never be cancelled are returned as is.
+func withoutCancel(ctx context.Context) context.Context {
+	if ctx.Done() == nil {
+		return ctx
+	}
+	return context.WithoutCancel(ctx)
+}
+
+// logMessageJSON logs the JSON representation of a message at debug level.
+// The message is only marshaled when debug logging is enabled.
+func (mb *MessageBus) logMessageJSON(
+	ctx context.Context,
+	msg string,
+	key string,
+	message any,
+) {
+	if !mb.logger.Enabled(ctx, slog.LevelDebug) {
 		return
 	}
-	status := "success"
+
+	messageJSON, err := json.Marshal(message)
+
 	if err != nil {
-		status = "error"
+		mb.logger.LogAttrs(ctx, slog.LevelDebug, msg,
+			slog.String("error", fmt.Sprintf("cannot marshal %s: %v", key, err)))
+		return
+	}
+
+	mb.logger.LogAttrs(ctx, slog.LevelDebug, msg,
+		slog.String(key, string(messageJSON)))
+}
+
+// correlationID returns the correlation ID that messages caused by a message
+// should carry. A message without a correlation ID started its chain, so its
+// own ID is used.
+func correlationID(messageID id.ID, messageCorrelationID id.ID) id.ID {
+	if messageCorrelationID.IsNil() {
+		return messageID
 	}
-	mb.metrics.ObserveCommand(command.GetType(), status, time.Since(start))
+	return messageCorrelationID
+}
+
+// linkEvents sets the causation of any events that haven't already been
+// linked to the message that caused them
+func linkEvents(events []messages.Event, causationID id.ID, correlationID id.ID) {
+	for _, event := range events {
+		if event.GetCausationID().IsNil() {
+			event.SetCausation(causationID, correlationID)
+		}
+	}
+}
+
+func (mb *MessageBus) observeCommandHandler(command messages.Command, err error, start time.Time) {
+	if mb.metrics == nil {
+		return
+	}
+	mb.metrics.ObserveCommand(command.GetType(), handlerStatus(err), time.Since(start))
 }
 
 func (mb *MessageBus) observeEventHandler(event messages.Event, err error, start time.Time) {
 	if mb.metrics == nil {
 		return
 	}
-	status := "success"
-	if err != nil {
-		status = "error"
+	mb.metrics.ObserveEvent(event.GetType(), handlerStatus(err), time.Since(start))
+}
+
+func (mb *MessageBus) observeCommandCascade(c messageBusCommand, events int) {
+	if mb.queueMetrics == nil || c.command == nil {
+		return
+	}
+	mb.queueMetrics.ObserveCommandCascade(
+		c.command.GetType(), events, time.Since(c.submitted),
+	)
+}
+
+func (mb *MessageBus) setEventQueueDepth() {
+	if mb.queueMetrics == nil {
+		return
+	}
+	mb.queueMetrics.SetEventQueueDepth(mb.eventsToProcess.len())
+}
+
+func (mb *MessageBus) setCommandsWaiting(count int64) {
+	if mb.queueMetrics == nil {
+		return
 	}
-	mb.metrics.ObserveEvent(event.GetType(), status, time.Since(start))
+	mb.queueMetrics.SetCommandsWaiting(int(count))
 }
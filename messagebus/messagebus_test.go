@@ -1,8 +1,11 @@
 package messagebus_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"log/slog"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -383,3 +386,182 @@ func TestDuplicateHandlerRegistration(t *testing.T) {
 	})
 	require.NoError(t, err)
 }
+
+// Test that commands issued by policies are processed after the current
+// cascade and are linked to the events that caused them
+func TestPolicies(t *testing.T) {
+	mb := messagebus.New()
+
+	type PlaceOrderCommand struct {
+		messages.BaseCommand
+	}
+
+	type OrderPlacedEvent struct {
+		messages.BaseEvent
+	}
+
+	type ReserveStockCommand struct {
+		messages.BaseCommand
+	}
+
+	type StockReservedEvent struct {
+		messages.BaseEvent
+	}
+
+	var executionOrder []string
+	var placeOrder *PlaceOrderCommand
+	var orderPlaced *OrderPlacedEvent
+	var reserveStock *ReserveStockCommand
+	var stockReserved *StockReservedEvent
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *PlaceOrderCommand) ([]messages.Event, error) {
+		executionOrder = append(executionOrder, "place-order")
+		orderPlaced = &OrderPlacedEvent{}
+		orderPlaced.Init("OrderPlaced")
+		return []messages.Event{orderPlaced}, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterPolicy(mb, func(ctx context.Context, evt *OrderPlacedEvent) ([]messages.Command, error) {
+		executionOrder = append(executionOrder, "policy")
+		reserveStock = &ReserveStockCommand{}
+		reserveStock.Init("ReserveStock")
+		return []messages.Command{reserveStock}, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *OrderPlacedEvent) ([]messages.Event, error) {
+		executionOrder = append(executionOrder, "order-placed")
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *ReserveStockCommand) ([]messages.Event, error) {
+		executionOrder = append(executionOrder, "reserve-stock")
+		stockReserved = &StockReservedEvent{}
+		stockReserved.Init("StockReserved")
+		return []messages.Event{stockReserved}, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *StockReservedEvent) ([]messages.Event, error) {
+		executionOrder = append(executionOrder, "stock-reserved")
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	go mb.Start(context.Background())
+
+	placeOrder = &PlaceOrderCommand{}
+	placeOrder.Init("PlaceOrder")
+
+	err = mb.HandleCommand(context.Background(), placeOrder)
+	require.NoError(t, err)
+
+	mb.Stop()
+
+	expected := []string{
+		"place-order", "order-placed", "policy", "reserve-stock", "stock-reserved",
+	}
+	require.Equal(t, expected, executionOrder)
+
+	rootID := placeOrder.ID.ID
+
+	require.Equal(t, rootID, orderPlaced.CausationID)
+	require.Equal(t, rootID, orderPlaced.CorrelationID)
+	require.Equal(t, orderPlaced.ID.ID, reserveStock.CausationID)
+	require.Equal(t, rootID, reserveStock.CorrelationID)
+	require.Equal(t, reserveStock.ID.ID, stockReserved.CausationID)
+	require.Equal(t, rootID, stockReserved.CorrelationID)
+}
+
+// Test that an inline MessageBus dispatches commands and their cascades on the
+// caller's goroutine without being started, serializing concurrent callers
+func TestInlineDispatch(t *testing.T) {
+	mb := messagebus.New(messagebus.WithInlineDispatch())
+
+	type IncrementCommand struct {
+		messages.BaseCommand
+	}
+
+	type IncrementedEvent struct {
+		messages.BaseEvent
+	}
+
+	commands := 0
+	events := 0
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *IncrementCommand) ([]messages.Event, error) {
+		commands++
+		return []messages.Event{&IncrementedEvent{}}, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *IncrementedEvent) ([]messages.Event, error) {
+		events++
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	err = mb.HandleCommand(context.Background(), &IncrementCommand{})
+	require.NoError(t, err)
+
+	// The cascade completes before HandleCommand returns
+	require.Equal(t, 1, commands)
+	require.Equal(t, 1, events)
+
+	var wg sync.WaitGroup
+
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, mb.HandleCommand(context.Background(), &IncrementCommand{}))
+		}()
+	}
+
+	wg.Wait()
+
+	require.Equal(t, 11, commands)
+	require.Equal(t, 11, events)
+
+	// Handlers can't be registered once commands have been handled
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *IncrementedEvent) ([]messages.Event, error) {
+		return nil, nil
+	})
+	require.Error(t, err)
+
+	mb.Stop()
+
+	err = mb.HandleCommand(context.Background(), &IncrementCommand{})
+	require.Error(t, err)
+}
+
+// Test that messages are logged as JSON only when debug logging is enabled
+func TestDebugMessageLogging(t *testing.T) {
+	type LoggedCommand struct {
+		messages.BaseCommand
+		Value string `json:"value"`
+	}
+
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo} {
+		var buf bytes.Buffer
+
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: level}))
+		mb := messagebus.New(messagebus.WithLogger(logger), messagebus.WithInlineDispatch())
+
+		err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *LoggedCommand) ([]messages.Event, error) {
+			return nil, nil
+		})
+		require.NoError(t, err)
+
+		err = mb.HandleCommand(context.Background(), &LoggedCommand{Value: "logged-value"})
+		require.NoError(t, err)
+
+		if level == slog.LevelDebug {
+			require.Contains(t, buf.String(), "logged-value")
+		} else {
+			require.NotContains(t, buf.String(), "logged-value")
+		}
+	}
+}
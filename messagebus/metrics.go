@@ -10,11 +10,30 @@ type MetricsHook interface {
 	ObserveEvent(eventType string, status string, duration time.Duration)
 }
 
+// QueueMetricsHook may optionally be implemented by a MetricsHook to receive
+// measurements about the MessageBus's queues and the cascade of events each
+// command produces
+type QueueMetricsHook interface {
+	// SetEventQueueDepth reports the number of events waiting to be
+	// dispatched to event handlers
+	SetEventQueueDepth(depth int)
+
+	// SetCommandsWaiting reports the number of HandleCommand callers waiting
+	// for the MessageBus to accept their command
+	SetCommandsWaiting(count int)
+
+	// ObserveCommandCascade reports the number of events dispatched as a
+	// result of a command and the time from the command being submitted until
+	// its cascade of events was fully processed
+	ObserveCommandCascade(commandType string, events int, inFlight time.Duration)
+}
+
 type Option func(*MessageBus)
 
 func WithMetricsHook(hook MetricsHook) Option {
 	return func(mb *MessageBus) {
 		mb.metrics = hook
+		mb.queueMetrics, _ = hook.(QueueMetricsHook)
 	}
 }
 
@@ -23,3 +42,21 @@ func WithLogger(logger *slog.Logger) Option {
 		mb.logger = logger
 	}
 }
+
+// WithDefaultHandlerTimeout limits how long command and event handlers may
+// run unless they are registered with their own timeout. Handlers that time
+// out are reported to the MetricsHook with a "timeout" status.
+func WithDefaultHandlerTimeout(timeout time.Duration) Option {
+	return func(mb *MessageBus) {
+		mb.defaultTimeout = timeout
+	}
+}
+
+// WithInlineDispatch configures the MessageBus to dispatch each command and
+// its cascade of events on the goroutine calling HandleCommand, so the
+// MessageBus doesn't need to be started. Dispatches are still serialized.
+func WithInlineDispatch() Option {
+	return func(mb *MessageBus) {
+		mb.inline = true
+	}
+}
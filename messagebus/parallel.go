@@ -0,0 +1,132 @@
+package messagebus
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// WithParallel runs the event handler at the same time as the other handlers
+// for the same event that run in parallel, rather than after the handlers
+// registered before it. Handlers that don't run in parallel still run one
+// after another while the parallel handlers run.
+//
+// The events emitted by every handler are queued in the order the handlers
+// were registered once they have all completed, and their errors are
+// combined. The number of handlers of an event running in parallel at once is
+// bounded by WithParallelLimit. Parallel handlers, as well as the MetricsHook
+// and logger, are invoked from multiple goroutines so they must be safe for
+// concurrent use.
+func WithParallel() HandlerOption {
+	return func(config *handlerConfig) {
+		config.parallel = true
+	}
+}
+
+// defaultParallelLimit is the number of handlers of an event that run in
+// parallel at once unless the MessageBus is created WithParallelLimit
+const defaultParallelLimit = 16
+
+// WithParallelLimit bounds the number of handlers of an event that run in
+// parallel at once, which is 16 by default. Parallel handlers beyond the
+// limit wait for a running handler to complete before they are started, so
+// the MessageBus never has more than limit handlers running in parallel. A
+// limit of 0 or less keeps the default.
+func WithParallelLimit(limit int) Option {
+	return func(mb *MessageBus) {
+		if limit > 0 {
+			mb.parallelLimit = limit
+		}
+	}
+}
+
+// WithParallelEvent runs all of the handlers for events of type E in
+// parallel, as if each had been registered WithParallel, with at most limit
+// running at once. A limit of 0 or less applies the MessageBus's parallel
+// limit.
+func WithParallelEvent[E messages.Event](limit int) Option {
+	var zero E
+
+	return func(mb *MessageBus) {
+		if mb.parallelEvents == nil {
+			mb.parallelEvents = make(map[reflect.Type]int)
+		}
+		mb.parallelEvents[reflect.TypeOf(zero)] = limit
+	}
+}
+
+// invokeEventHandlersInParallel invokes the handlers of a route with handlers
+// that run in parallel, and queues the events they emit in the order the
+// handlers were registered. contexts holds the context of each handler.
+func (mb *MessageBus) invokeEventHandlersInParallel(
+	contexts []messageInfoContext,
+	queued queuedEvent,
+	route eventRoute,
+) {
+	type result struct {
+		events []messages.Event
+		err    error
+	}
+
+	limit := route.limit
+
+	if limit <= 0 {
+		limit = mb.parallelLimit
+	}
+
+	var (
+		results = make([]result, len(route.handlers))
+		wg      sync.WaitGroup
+		slots   = make(chan struct{}, limit)
+	)
+
+	// Parallel handlers are started as slots become available, while the
+	// handlers that don't run in parallel run on this goroutine
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i, handler := range route.handlers {
+			if !handler.parallel {
+				continue
+			}
+
+			slots <- struct{}{}
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+
+				results[i].events, results[i].err = mb.invokeEventHandler(&contexts[i], queued, handler)
+			}()
+		}
+	}()
+
+	for i, handler := range route.handlers {
+		if handler.parallel {
+			continue
+		}
+
+		results[i].events, results[i].err = mb.invokeEventHandler(&contexts[i], queued, handler)
+	}
+
+	wg.Wait()
+
+	var errs []error
+
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+
+		mb.enqueueEvents(queued, r.events)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		mb.logger.Error("invoking event handlers failed", "error", err.Error())
+	}
+}
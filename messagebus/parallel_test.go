@@ -0,0 +1,158 @@
+package messagebus_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+type orderPlacedEvent struct {
+	messages.BaseEvent
+}
+
+type notificationSentEvent struct {
+	messages.BaseEvent
+	Channel string
+}
+
+type placeOrderCommand struct {
+	messages.BaseCommand
+}
+
+// Test that parallel event handlers run at the same time and their events are
+// queued in registration order
+func TestParallelEventHandlers(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	mb := messagebus.New(
+		messagebus.WithInlineDispatch(),
+		messagebus.WithLogger(logger),
+	)
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *placeOrderCommand) ([]messages.Event, error) {
+		return []messages.Event{&orderPlacedEvent{}}, nil
+	})
+	require.NoError(t, err)
+
+	// Each handler waits for all of the others to start, which only
+	// completes if they run in parallel
+	var started sync.WaitGroup
+	started.Add(3)
+
+	for _, channel := range []string{"email", "sms", "webhook"} {
+		err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *orderPlacedEvent) ([]messages.Event, error) {
+			started.Done()
+			started.Wait()
+
+			// The first handler completes last
+			if channel == "email" {
+				time.Sleep(10 * time.Millisecond)
+				return nil, errors.New("email failed")
+			}
+
+			if channel == "webhook" {
+				return nil, errors.New("webhook failed")
+			}
+
+			return []messages.Event{&notificationSentEvent{Channel: channel}}, nil
+		}, messagebus.WithParallel())
+		require.NoError(t, err)
+	}
+
+	// A handler that isn't parallel runs while the parallel handlers run
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *orderPlacedEvent) ([]messages.Event, error) {
+		return []messages.Event{&notificationSentEvent{Channel: "push"}}, nil
+	})
+	require.NoError(t, err)
+
+	var channels []string
+
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *notificationSentEvent) ([]messages.Event, error) {
+		channels = append(channels, evt.Channel)
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	err = mb.HandleCommand(context.Background(), &placeOrderCommand{})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"sms", "push"}, channels)
+	require.Contains(t, logs.String(), "email failed\\nwebhook failed")
+}
+
+// Test that the number of handlers running in parallel for an event type is
+// limited
+func TestParallelEventLimit(t *testing.T) {
+	mb := messagebus.New(
+		messagebus.WithInlineDispatch(),
+		messagebus.WithParallelEvent[*orderPlacedEvent](2),
+	)
+
+	requireParallelLimit(t, mb, 2)
+}
+
+// Test that handlers registered WithParallel are limited by the MessageBus's
+// parallel limit
+func TestParallelLimit(t *testing.T) {
+	mb := messagebus.New(
+		messagebus.WithInlineDispatch(),
+		messagebus.WithParallelLimit(3),
+	)
+
+	requireParallelLimit(t, mb, 3, messagebus.WithParallel())
+}
+
+// requireParallelLimit registers handlers for orderPlacedEvent and verifies
+// that at most limit of them run at once
+func requireParallelLimit(
+	t *testing.T,
+	mb *messagebus.MessageBus,
+	limit int64,
+	opts ...messagebus.HandlerOption,
+) {
+	t.Helper()
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *placeOrderCommand) ([]messages.Event, error) {
+		return []messages.Event{&orderPlacedEvent{}}, nil
+	})
+	require.NoError(t, err)
+
+	var running, maxRunning, handled atomic.Int64
+
+	for range 6 {
+		err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *orderPlacedEvent) ([]messages.Event, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+
+			for {
+				current := maxRunning.Load()
+				if n <= current || maxRunning.CompareAndSwap(current, n) {
+					break
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			handled.Add(1)
+
+			return nil, nil
+		}, opts...)
+		require.NoError(t, err)
+	}
+
+	err = mb.HandleCommand(context.Background(), &placeOrderCommand{})
+	require.NoError(t, err)
+
+	require.Equal(t, int64(6), handled.Load())
+	require.Equal(t, limit, maxRunning.Load())
+}
@@ -0,0 +1,281 @@
+package messagebus
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDurationBuckets are the upper bounds, in seconds, of the buckets used
+// for handler duration histograms. They match the Prometheus client defaults.
+var defaultDurationBuckets = []float64{
+	.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// defaultCascadeBuckets are the upper bounds of the buckets used for the
+// number of events dispatched as a result of a command
+var defaultCascadeBuckets = []float64{0, 1, 2, 5, 10, 25, 50, 100}
+
+// PrometheusMetrics is a MetricsHook that records MessageBus measurements and
+// exposes them in the Prometheus text exposition format. It implements
+// http.Handler so it can be mounted directly as a scrape endpoint.
+type PrometheusMetrics struct {
+	namespace string
+
+	mu              sync.Mutex
+	commands        map[metricLabels]*histogram
+	events          map[metricLabels]*histogram
+	commandInFlight map[metricLabels]*histogram
+	commandCascade  map[metricLabels]*histogram
+	eventQueueDepth int
+	commandsWaiting int
+}
+
+// NewPrometheusMetrics creates a PrometheusMetrics whose metric names are
+// prefixed with the namespace provided. If namespace is empty "messagebus" is
+// used.
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	if namespace == "" {
+		namespace = "messagebus"
+	}
+
+	return &PrometheusMetrics{
+		namespace:       namespace,
+		commands:        make(map[metricLabels]*histogram),
+		events:          make(map[metricLabels]*histogram),
+		commandInFlight: make(map[metricLabels]*histogram),
+		commandCascade:  make(map[metricLabels]*histogram),
+	}
+}
+
+func (p *PrometheusMetrics) ObserveCommand(
+	commandType string,
+	status string,
+	duration time.Duration,
+) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	observe(p.commands, defaultDurationBuckets,
+		metricLabels{typ: commandType, status: status}, duration.Seconds())
+}
+
+func (p *PrometheusMetrics) ObserveEvent(
+	eventType string,
+	status string,
+	duration time.Duration,
+) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	observe(p.events, defaultDurationBuckets,
+		metricLabels{typ: eventType, status: status}, duration.Seconds())
+}
+
+func (p *PrometheusMetrics) SetEventQueueDepth(depth int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.eventQueueDepth = depth
+}
+
+func (p *PrometheusMetrics) SetCommandsWaiting(count int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.commandsWaiting = count
+}
+
+func (p *PrometheusMetrics) ObserveCommandCascade(
+	commandType string,
+	events int,
+	inFlight time.Duration,
+) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	labels := metricLabels{typ: commandType}
+
+	observe(p.commandInFlight, defaultDurationBuckets, labels, inFlight.Seconds())
+	observe(p.commandCascade, defaultCascadeBuckets, labels, float64(events))
+}
+
+// ServeHTTP writes the current metrics in the Prometheus text exposition
+// format
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if err := p.WriteMetrics(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteMetrics writes the current metrics in the Prometheus text exposition
+// format to w
+func (p *PrometheusMetrics) WriteMetrics(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+
+	p.writeCounter(&b, "commands_total",
+		"Number of commands handled by the MessageBus.", p.commands)
+	p.writeHistogram(&b, "command_duration_seconds",
+		"Duration of command handler invocations.", p.commands)
+	p.writeCounter(&b, "events_total",
+		"Number of event handler invocations by the MessageBus.", p.events)
+	p.writeHistogram(&b, "event_duration_seconds",
+		"Duration of event handler invocations.", p.events)
+	p.writeHistogram(&b, "command_in_flight_seconds",
+		"Time from a command being submitted until its events were processed.",
+		p.commandInFlight)
+	p.writeHistogram(&b, "command_cascade_events",
+		"Number of events dispatched as a result of a command.",
+		p.commandCascade)
+	p.writeGauge(&b, "event_queue_depth",
+		"Number of events waiting to be dispatched.", p.eventQueueDepth)
+	p.writeGauge(&b, "commands_waiting",
+		"Number of commands waiting to be accepted by the MessageBus.",
+		p.commandsWaiting)
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func (p *PrometheusMetrics) writeCounter(
+	b *strings.Builder,
+	name string,
+	help string,
+	histograms map[metricLabels]*histogram,
+) {
+	name = p.namespace + "_" + name
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+
+	for _, labels := range sortedLabels(histograms) {
+		fmt.Fprintf(b, "%s{%s} %d\n",
+			name, labels.format(), histograms[labels].count)
+	}
+}
+
+func (p *PrometheusMetrics) writeHistogram(
+	b *strings.Builder,
+	name string,
+	help string,
+	histograms map[metricLabels]*histogram,
+) {
+	name = p.namespace + "_" + name
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	for _, labels := range sortedLabels(histograms) {
+		h := histograms[labels]
+		formatted := labels.format()
+
+		for i, upper := range h.buckets {
+			fmt.Fprintf(b, "%s_bucket{%s,le=%q} %d\n",
+				name, formatted, formatFloat(upper), h.counts[i])
+		}
+
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, formatted, h.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, formatted, formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, formatted, h.count)
+	}
+}
+
+func (p *PrometheusMetrics) writeGauge(
+	b *strings.Builder,
+	name string,
+	help string,
+	value int,
+) {
+	name = p.namespace + "_" + name
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n",
+		name, help, name, name, value)
+}
+
+// metricLabels identifies a single series within a metric family. An empty
+// status is omitted from the series' labels.
+type metricLabels struct {
+	typ    string
+	status string
+}
+
+func (l metricLabels) format() string {
+	formatted := `type="` + escapeLabelValue(l.typ) + `"`
+
+	if l.status != "" {
+		formatted += `,status="` + escapeLabelValue(l.status) + `"`
+	}
+
+	return formatted
+}
+
+func sortedLabels(histograms map[metricLabels]*histogram) []metricLabels {
+	labels := make([]metricLabels, 0, len(histograms))
+
+	for l := range histograms {
+		labels = append(labels, l)
+	}
+
+	slices.SortFunc(labels, func(a, b metricLabels) int {
+		if c := strings.Compare(a.typ, b.typ); c != 0 {
+			return c
+		}
+		return strings.Compare(a.status, b.status)
+	})
+
+	return labels
+}
+
+// histogram is a cumulative histogram with fixed bucket upper bounds
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func observe(
+	histograms map[metricLabels]*histogram,
+	buckets []float64,
+	labels metricLabels,
+	value float64,
+) {
+	h, ok := histograms[labels]
+
+	if !ok {
+		h = &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		histograms[labels] = h
+	}
+
+	for i, upper := range h.buckets {
+		if value <= upper {
+			h.counts[i]++
+		}
+	}
+
+	h.count++
+	h.sum += value
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
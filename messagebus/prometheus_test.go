@@ -0,0 +1,73 @@
+package messagebus_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// Test that the Prometheus adapter exposes handler and cascade metrics
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := messagebus.NewPrometheusMetrics("dorky")
+	mb := messagebus.New(messagebus.WithMetricsHook(metrics))
+
+	type PlaceCommand struct {
+		messages.BaseCommand
+	}
+
+	type PlacedEvent struct {
+		messages.BaseEvent
+	}
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *PlaceCommand) ([]messages.Event, error) {
+		if cmd.Type == "fail" {
+			return nil, fmt.Errorf("failed")
+		}
+		evt := &PlacedEvent{}
+		evt.Init("Placed")
+		return []messages.Event{evt, evt}, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *PlacedEvent) ([]messages.Event, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	go mb.Start(context.Background())
+
+	ok := &PlaceCommand{}
+	ok.Init("Place")
+	require.NoError(t, mb.HandleCommand(context.Background(), ok))
+
+	failing := &PlaceCommand{}
+	failing.Init("fail")
+	require.Error(t, mb.HandleCommand(context.Background(), failing))
+
+	mb.Stop()
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+
+	require.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	require.Contains(t, body, "# TYPE dorky_commands_total counter\n")
+	require.Contains(t, body, `dorky_commands_total{type="Place",status="success"} 1`)
+	require.Contains(t, body, `dorky_commands_total{type="fail",status="error"} 1`)
+	require.Contains(t, body, `dorky_events_total{type="Placed",status="success"} 2`)
+	require.Contains(t, body, "# TYPE dorky_command_duration_seconds histogram\n")
+	require.Contains(t, body, `dorky_command_duration_seconds_count{type="Place",status="success"} 1`)
+	require.Contains(t, body, `dorky_command_cascade_events_bucket{type="Place",le="1"} 0`)
+	require.Contains(t, body, `dorky_command_cascade_events_bucket{type="Place",le="2"} 1`)
+	require.Contains(t, body, `dorky_command_cascade_events_sum{type="Place"} 2`)
+	require.Contains(t, body, `dorky_command_in_flight_seconds_count{type="fail"} 1`)
+	require.Contains(t, body, "dorky_event_queue_depth 0\n")
+	require.Contains(t, body, "dorky_commands_waiting 0\n")
+}
@@ -1,8 +1,11 @@
 package messagebus
 
-// Queue implements a generic queue
+// Queue implements a generic queue. Storage is reused once the queue has been
+// drained, so a queue that is repeatedly filled and emptied stops allocating
+// once it has grown to its working size.
 type Queue[T any] struct {
 	items []T
+	head  int
 }
 
 func NewQueue[T any]() *Queue[T] {
@@ -21,13 +24,23 @@ func (q *Queue[T]) enqueueMultiple(items []T) {
 
 func (q *Queue[T]) dequeue() (T, bool) {
 	var zero T
-	if len(q.items) == 0 {
+	if q.head == len(q.items) {
 		return zero, false
 	}
 
-	item := q.items[0]
-	q.items[0] = zero // Clear reference to prevent memory leak
-	q.items = q.items[1:]
+	item := q.items[q.head]
+	q.items[q.head] = zero // Clear reference to prevent memory leak
+	q.head++
+
+	// Once drained, rewind so the backing storage is reused
+	if q.head == len(q.items) {
+		q.items = q.items[:0]
+		q.head = 0
+	}
 
 	return item, true
 }
+
+func (q *Queue[T]) len() int {
+	return len(q.items) - q.head
+}
@@ -0,0 +1,218 @@
+package messagebus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+const handlerSignature = "func(context.Context, M) ([]messages.Event, error)"
+
+var (
+	contextType = reflect.TypeFor[context.Context]()
+	commandType = reflect.TypeFor[messages.Command]()
+	eventType   = reflect.TypeFor[messages.Event]()
+	eventsType  = reflect.TypeFor[[]messages.Event]()
+	errorType   = reflect.TypeFor[error]()
+)
+
+// RegisterHandlers registers the methods of svc that handle commands or
+// events with the MessageBus, as if each had been registered with
+// RegisterCommandHandler or RegisterEventHandler. The options provided apply
+// to every handler registered.
+//
+// Every exported method with a Command or an Event parameter is a handler,
+// and must have the signature func(context.Context, M) ([]messages.Event,
+// error). Methods without such a parameter are ignored.
+//
+// Handlers are only registered if all of them are valid. Otherwise the
+// errors for every invalid handler are returned, including handlers with a
+// different signature and handlers for commands that already have one.
+func RegisterHandlers(mb *MessageBus, svc any, opts ...HandlerOption) error {
+	v := reflect.ValueOf(svc)
+
+	if !v.IsValid() || v.Kind() == reflect.Pointer && v.IsNil() {
+		return dorkyerrors.NewInvalid(
+			"invalid_handler_service",
+			"cannot register handlers of a nil service",
+		)
+	}
+
+	var (
+		commandHandlers []registeredHandler[commandHandler]
+		eventHandlers   []registeredHandler[eventHandler]
+		commandTypes    = make(map[reflect.Type]struct{})
+		errs            []error
+	)
+
+	for i := range v.NumMethod() {
+		method := v.Type().Method(i)
+		methodType := method.Type
+
+		// The method's type includes its receiver as the first parameter
+		messageIndex := messageParameter(methodType)
+
+		if messageIndex < 0 {
+			continue
+		}
+
+		messageType := methodType.In(messageIndex)
+		isCommand := implements(messageType, commandType)
+		isEvent := implements(messageType, eventType)
+
+		name := fmt.Sprintf("%v.%s", v.Type(), method.Name)
+
+		switch {
+		case messageIndex != 2 ||
+			methodType.NumIn() != 3 ||
+			methodType.In(1) != contextType ||
+			methodType.NumOut() != 2 ||
+			methodType.Out(0) != eventsType ||
+			methodType.Out(1) != errorType:
+			errs = append(errs, invalidHandlerError(
+				name,
+				fmt.Sprintf("handler %s must have the signature %s", name, handlerSignature),
+			))
+			continue
+		case messageType.Kind() == reflect.Interface:
+			errs = append(errs, invalidHandlerError(
+				name,
+				fmt.Sprintf("handler %s must handle a concrete message type", name),
+			))
+			continue
+		case !messageType.Implements(commandType) && !messageType.Implements(eventType):
+			errs = append(errs, invalidHandlerError(
+				name,
+				fmt.Sprintf("handler %s must handle *%v rather than %v", name, messageType, messageType),
+			))
+			continue
+		case isCommand && isEvent:
+			errs = append(errs, invalidHandlerError(
+				name,
+				fmt.Sprintf("handler %s handles %v, which is both a command and an event", name, messageType),
+			))
+			continue
+		}
+
+		handle := methodHandler(v.Method(i))
+
+		if isEvent {
+			eventHandlers = append(eventHandlers, registeredHandler[eventHandler]{
+				messageType: messageType,
+				handler: eventHandler{
+					handle: func(ctx context.Context, evt messages.Event) ([]messages.Event, error) {
+						return handle(ctx, evt)
+					},
+					handlerConfig: newHandlerConfig(opts, name),
+				},
+			})
+			continue
+		}
+
+		_, registered := mb.commandHandlers[messageType]
+		_, duplicate := commandTypes[messageType]
+
+		if registered || duplicate {
+			errs = append(errs, dorkyerrors.NewConflict(
+				"handler_already_registered",
+				fmt.Sprintf("handler %s is not the only handler for command type %v", name, messageType),
+			).WithDetail("type", messageType.String()).WithDetail("method", name))
+			continue
+		}
+
+		commandTypes[messageType] = struct{}{}
+		commandHandlers = append(commandHandlers, registeredHandler[commandHandler]{
+			messageType: messageType,
+			handler: commandHandler{
+				handle: func(ctx context.Context, cmd messages.Command) ([]messages.Event, error) {
+					return handle(ctx, cmd)
+				},
+				handlerConfig: newHandlerConfig(opts, name),
+			},
+		})
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if len(commandHandlers) == 0 && len(eventHandlers) == 0 {
+		return dorkyerrors.NewInvalid(
+			"invalid_handler_service",
+			fmt.Sprintf("%v has no handler methods", v.Type()),
+		)
+	}
+
+	for _, registered := range commandHandlers {
+		if err := mb.registerCommandHandler(registered.messageType, registered.handler); err != nil {
+			return err
+		}
+	}
+
+	for _, registered := range eventHandlers {
+		if err := mb.registerEventHandler(registered.messageType, registered.handler); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type registeredHandler[H any] struct {
+	messageType reflect.Type
+	handler     H
+}
+
+// messageParameter returns the index of the first parameter of a method's
+// type that is a Command or an Event, or -1 if there is none
+func messageParameter(methodType reflect.Type) int {
+	for i := 1; i < methodType.NumIn(); i++ {
+		in := methodType.In(i)
+
+		if implements(in, commandType) || implements(in, eventType) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// implements determines if messageType implements the message interface
+// provided. Messages are usually implemented by pointers to structs, so a
+// handler taking the struct itself is also recognized in order to report it.
+func implements(messageType reflect.Type, message reflect.Type) bool {
+	if messageType.Implements(message) {
+		return true
+	}
+
+	return messageType.Kind() != reflect.Pointer &&
+		messageType.Kind() != reflect.Interface &&
+		reflect.PointerTo(messageType).Implements(message)
+}
+
+func invalidHandlerError(name string, message string) error {
+	return dorkyerrors.NewInvalid("invalid_handler", message).
+		WithDetail("method", name)
+}
+
+// methodHandler adapts a handler method with a valid signature to a function
+// handling any message
+func methodHandler(
+	method reflect.Value,
+) func(context.Context, any) ([]messages.Event, error) {
+	return func(ctx context.Context, message any) ([]messages.Event, error) {
+		out := method.Call([]reflect.Value{
+			reflect.ValueOf(&ctx).Elem(),
+			reflect.ValueOf(message),
+		})
+
+		events, _ := out[0].Interface().([]messages.Event)
+		err, _ := out[1].Interface().(error)
+
+		return events, err
+	}
+}
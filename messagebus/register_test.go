@@ -0,0 +1,109 @@
+package messagebus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// Test that handler methods of a service are all registered at once
+func TestRegisterHandlers(t *testing.T) {
+	mb := messagebus.New()
+
+	svc := &OrderService{}
+
+	err := messagebus.RegisterHandlers(mb, svc)
+	require.NoError(t, err)
+
+	go mb.Start(context.Background())
+
+	err = mb.HandleCommand(context.Background(), &CreateOrderCommand{OrderID: "123"})
+	require.NoError(t, err)
+
+	err = mb.HandleCommand(context.Background(), &ShipOrderCommand{OrderID: "123"})
+	require.NoError(t, err)
+
+	mb.Stop()
+
+	require.Equal(t, 1, svc.ordersCreated)
+	require.Equal(t, 1, svc.ordersShipped)
+}
+
+type invalidOrderService struct{}
+
+func (s *invalidOrderService) HandleCreateOrder(ctx context.Context, cmd *CreateOrderCommand) error {
+	return nil
+}
+
+func (s *invalidOrderService) HandleShipOrder(ctx context.Context, cmd ShipOrderCommand) ([]messages.Event, error) {
+	return nil, nil
+}
+
+func (s *invalidOrderService) OnOrderCreated(ctx context.Context, evt *OrderCreatedEvent) ([]messages.Event, error) {
+	return nil, nil
+}
+
+func (s *invalidOrderService) NoContext(cmd *CreateOrderCommand) ([]messages.Event, error) {
+	return nil, nil
+}
+
+func (s *invalidOrderService) ContextSecond(cmd *ShipOrderCommand, ctx context.Context) ([]messages.Event, error) {
+	return nil, nil
+}
+
+func (s *invalidOrderService) ExtraParameter(ctx context.Context, evt *OrderCreatedEvent, n int) ([]messages.Event, error) {
+	return nil, nil
+}
+
+// Helper methods that don't take a message aren't handlers
+func (s *invalidOrderService) Close(ctx context.Context) error {
+	return nil
+}
+
+type duplicateOrderService struct{}
+
+func (s *duplicateOrderService) CreateOrder(ctx context.Context, cmd *CreateOrderCommand) ([]messages.Event, error) {
+	return nil, nil
+}
+
+func (s *duplicateOrderService) HandleCreateOrder(ctx context.Context, cmd *CreateOrderCommand) ([]messages.Event, error) {
+	return nil, nil
+}
+
+// Test that invalid handler methods are reported and nothing is registered
+func TestRegisterHandlersErrors(t *testing.T) {
+	mb := messagebus.New(messagebus.WithInlineDispatch())
+
+	err := messagebus.RegisterHandlers(mb, &invalidOrderService{})
+	require.Error(t, err)
+	require.True(t, dorkyerrors.HasCategory(err, dorkyerrors.Invalid))
+	require.ErrorContains(t, err, "invalidOrderService.HandleCreateOrder must have the signature")
+	require.ErrorContains(t, err, "invalidOrderService.HandleShipOrder must handle *messagebus_test.ShipOrderCommand")
+	require.ErrorContains(t, err, "invalidOrderService.NoContext must have the signature")
+	require.ErrorContains(t, err, "invalidOrderService.ContextSecond must have the signature")
+	require.ErrorContains(t, err, "invalidOrderService.ExtraParameter must have the signature")
+	require.NotContains(t, err.Error(), "Close")
+
+	err = messagebus.RegisterHandlers(mb, &duplicateOrderService{})
+	require.Error(t, err)
+	require.Equal(t, "handler_already_registered", dorkyerrors.CodeOf(err))
+
+	err = messagebus.RegisterHandlers(mb, duplicateOrderService{})
+	require.Equal(t, "invalid_handler_service", dorkyerrors.CodeOf(err))
+
+	// Commands that already have a handler are reported
+	svc := &OrderService{}
+	require.NoError(t, messagebus.RegisterCommandHandler(mb, svc.HandleShipOrder))
+
+	err = messagebus.RegisterHandlers(mb, svc)
+	require.Equal(t, "handler_already_registered", dorkyerrors.CodeOf(err))
+
+	// The valid handlers of the invalid services were not registered
+	err = mb.HandleCommand(context.Background(), &CreateOrderCommand{OrderID: "123"})
+	require.Equal(t, "no_command_handler", dorkyerrors.CodeOf(err))
+}
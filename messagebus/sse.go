@@ -0,0 +1,244 @@
+package messagebus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// EventStore provides access to previously dispatched events so that Server
+// Sent Events clients can resume a stream after reconnecting
+type EventStore interface {
+	// EventsAfter returns the events dispatched after the event with the ID
+	// provided, in the order they were dispatched
+	EventsAfter(ctx context.Context, eventID messages.EventID) ([]messages.Event, error)
+}
+
+// SSEHandler is an http.Handler that streams events dispatched by a
+// MessageBus to clients as Server Sent Events.
+//
+// Clients select events with query parameters, each of which may be
+// repeated:
+//   - type: the event's type
+//   - entity_type: the type of entity the event belongs to
+//   - entity_id: the ID of the entity the event belongs to
+//
+// Each event is sent with its ID, its type as the SSE event name, and its
+// JSON representation as data. When an EventStore is configured, clients
+// reconnecting with a Last-Event-ID header first receive the events they
+// missed.
+//
+// Clients that don't keep up with the stream are disconnected, and can
+// resume from the last event they received.
+type SSEHandler struct {
+	mb        *MessageBus
+	store     EventStore
+	buffer    int
+	heartbeat time.Duration
+	logger    *slog.Logger
+}
+
+type SSEOption func(*SSEHandler)
+
+// WithSSEEventStore sets the EventStore used to resume streams for clients
+// that send a Last-Event-ID header
+func WithSSEEventStore(store EventStore) SSEOption {
+	return func(h *SSEHandler) {
+		h.store = store
+	}
+}
+
+// WithSSEBuffer sets the number of events buffered for each client. The
+// default is 64.
+func WithSSEBuffer(size int) SSEOption {
+	return func(h *SSEHandler) {
+		h.buffer = size
+	}
+}
+
+// WithSSEHeartbeat sets the interval at which comments are sent to idle
+// clients to keep their connections open. The default is 15 seconds, and 0
+// disables heartbeats.
+func WithSSEHeartbeat(interval time.Duration) SSEOption {
+	return func(h *SSEHandler) {
+		h.heartbeat = interval
+	}
+}
+
+func WithSSELogger(logger *slog.Logger) SSEOption {
+	return func(h *SSEHandler) {
+		h.logger = logger
+	}
+}
+
+func NewSSEHandler(mb *MessageBus, opts ...SSEOption) *SSEHandler {
+	h := &SSEHandler{
+		mb:        mb,
+		buffer:    defaultSubscriptionBuffer,
+		heartbeat: 15 * time.Second,
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.logger == nil {
+		h.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return h
+}
+
+func (h *SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var lastEventID messages.EventID
+
+	if header := r.Header.Get("Last-Event-ID"); header != "" && h.store != nil {
+		var err error
+
+		lastEventID, err = messages.ParseEventID(header)
+
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter := sseFilter(r)
+
+	// Subscribe before replaying missed events so that none are lost between
+	// the two
+	events, cancel := h.mb.Subscribe(
+		filter,
+		WithSubscriptionBuffer(h.buffer),
+		WithOverflowPolicy(OverflowDisconnect),
+	)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+
+	// replayed holds the IDs of replayed events, which may also be received
+	// from the subscription
+	replayed := make(map[messages.EventID]struct{})
+
+	if !lastEventID.IsNil() {
+		missed, err := h.store.EventsAfter(ctx, lastEventID)
+
+		if err != nil {
+			h.logger.Error("cannot load missed events", "error", err.Error())
+			return
+		}
+
+		for _, event := range missed {
+			if !filter(event) {
+				continue
+			}
+
+			if err := writeSSEEvent(w, event); err != nil {
+				h.logger.Error("cannot write event", "error", err.Error())
+				return
+			}
+
+			replayed[event.GetID()] = struct{}{}
+		}
+
+		flusher.Flush()
+	}
+
+	var heartbeat <-chan time.Time
+
+	if h.heartbeat > 0 {
+		ticker := time.NewTicker(h.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// The client fell behind and was disconnected
+				return
+			}
+
+			if _, ok := replayed[event.GetID()]; ok {
+				delete(replayed, event.GetID())
+				continue
+			}
+
+			if err := writeSSEEvent(w, event); err != nil {
+				h.logger.Error("cannot write event", "error", err.Error())
+				return
+			}
+
+			flusher.Flush()
+		case <-heartbeat:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+
+			flusher.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sseFilter builds an EventFilter from the request's query parameters
+func sseFilter(r *http.Request) EventFilter {
+	query := r.URL.Query()
+
+	types := query["type"]
+	entityTypes := query["entity_type"]
+	entityIDs := query["entity_id"]
+
+	return func(evt messages.Event) bool {
+		if len(types) > 0 && !slices.Contains(types, evt.GetType()) {
+			return false
+		}
+
+		if len(entityTypes) > 0 && !slices.Contains(entityTypes, evt.GetEntityType()) {
+			return false
+		}
+
+		if len(entityIDs) > 0 && !slices.Contains(entityIDs, evt.GetEntityID().String()) {
+			return false
+		}
+
+		return true
+	}
+}
+
+func writeSSEEvent(w io.Writer, event messages.Event) error {
+	data, err := json.Marshal(event)
+
+	if err != nil {
+		return fmt.Errorf("cannot marshal event %v: %w", event.GetID(), err)
+	}
+
+	_, err = fmt.Fprintf(
+		w, "id: %s\nevent: %s\ndata: %s\n\n", event.GetID(), event.GetType(), data,
+	)
+
+	return err
+}
@@ -0,0 +1,137 @@
+package messagebus_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/id"
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+type sseCommand struct {
+	messages.BaseCommand
+	Names []string
+}
+
+type sseEvent struct {
+	messages.BaseEvent
+	Name string `json:"name"`
+}
+
+// sseEventStore records every dispatched event so streams can be resumed
+type sseEventStore struct {
+	mu     sync.Mutex
+	events []messages.Event
+}
+
+func (s *sseEventStore) append(evt messages.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, evt)
+}
+
+func (s *sseEventStore) EventsAfter(
+	ctx context.Context,
+	eventID messages.EventID,
+) ([]messages.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := slices.IndexFunc(s.events, func(e messages.Event) bool {
+		return e.GetID() == eventID
+	})
+	return slices.Clone(s.events[idx+1:]), nil
+}
+
+// readSSEEvents reads count events from the stream, returning their data lines
+func readSSEEvents(t *testing.T, reader *bufio.Reader, count int) []string {
+	var data []string
+
+	for len(data) < count {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+
+		if after, ok := strings.CutPrefix(line, "data: "); ok {
+			data = append(data, strings.TrimSpace(after))
+		}
+	}
+
+	return data
+}
+
+// Test that dispatched events are streamed to SSE clients using their filters
+// and that clients can resume from the last event they received
+func TestSSEHandler(t *testing.T) {
+	mb := messagebus.New(messagebus.WithInlineDispatch())
+	store := &sseEventStore{}
+	entityID := id.ID{GoogleUUID: [16]byte{1}}
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *sseCommand) ([]messages.Event, error) {
+		var events []messages.Event
+		for _, name := range cmd.Names {
+			evt := &sseEvent{Name: name}
+			evt.Init("Named")
+			evt.SetEntity("thing", entityID)
+			events = append(events, evt)
+		}
+		return events, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(mb, func(ctx context.Context, evt *sseEvent) ([]messages.Event, error) {
+		store.append(evt)
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	server := httptest.NewServer(messagebus.NewSSEHandler(mb, messagebus.WithSSEEventStore(store)))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(
+		ctx, "GET", server.URL+"?type=Named&entity_type=thing&entity_id="+entityID.String(), nil,
+	)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	err = mb.HandleCommand(context.Background(), &sseCommand{Names: []string{"a", "b", "c"}})
+	require.NoError(t, err)
+
+	data := readSSEEvents(t, bufio.NewReader(resp.Body), 3)
+	require.Contains(t, data[0], `"name":"a"`)
+	require.Contains(t, data[0], `"entity_type":"thing"`)
+	require.Contains(t, data[2], `"name":"c"`)
+
+	// Resume after the first event
+	resumeReq, err := http.NewRequestWithContext(ctx, "GET", server.URL+"?type=Named", nil)
+	require.NoError(t, err)
+	resumeReq.Header.Set("Last-Event-ID", store.events[0].GetID().String())
+
+	resumeResp, err := http.DefaultClient.Do(resumeReq)
+	require.NoError(t, err)
+	defer resumeResp.Body.Close()
+
+	err = mb.HandleCommand(context.Background(), &sseCommand{Names: []string{"d"}})
+	require.NoError(t, err)
+
+	data = readSSEEvents(t, bufio.NewReader(resumeResp.Body), 3)
+	require.Contains(t, data[0], `"name":"b"`)
+	require.Contains(t, data[1], `"name":"c"`)
+	require.Contains(t, data[2], `"name":"d"`)
+}
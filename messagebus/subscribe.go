@@ -0,0 +1,205 @@
+package messagebus
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/dmpettyp/dorky/messages"
+)
+
+// EventFilter selects the events delivered to a subscriber. A nil EventFilter
+// selects all events.
+type EventFilter func(messages.Event) bool
+
+// EventsOfType returns an EventFilter selecting events of type E, matching
+// events the same way event handlers registered for E are matched
+func EventsOfType[E messages.Event]() EventFilter {
+	var zero E
+	eventType := reflect.TypeOf(zero)
+
+	return func(evt messages.Event) bool {
+		return reflect.TypeOf(evt) == eventType
+	}
+}
+
+// AnyOf returns an EventFilter selecting events selected by any of the
+// filters provided
+func AnyOf(filters ...EventFilter) EventFilter {
+	return func(evt messages.Event) bool {
+		for _, filter := range filters {
+			if filter == nil || filter(evt) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// OverflowPolicy determines what happens when an event is dispatched to a
+// subscriber whose buffer is full
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest discards the oldest buffered event to make room
+	OverflowDropOldest OverflowPolicy = iota
+
+	// OverflowDropNewest discards the event being dispatched
+	OverflowDropNewest
+
+	// OverflowDisconnect cancels the subscription, closing its channel
+	OverflowDisconnect
+)
+
+const defaultSubscriptionBuffer = 64
+
+type SubscribeOption func(*subscriber)
+
+// WithSubscriptionBuffer sets the number of events buffered for a subscriber
+// that isn't keeping up. The default is 64.
+func WithSubscriptionBuffer(size int) SubscribeOption {
+	return func(s *subscriber) {
+		s.buffer = size
+	}
+}
+
+// WithOverflowPolicy sets how a subscriber's full buffer is handled. The
+// default is OverflowDropOldest.
+func WithOverflowPolicy(policy OverflowPolicy) SubscribeOption {
+	return func(s *subscriber) {
+		s.policy = policy
+	}
+}
+
+// Subscribe streams events dispatched by the MessageBus that are selected by
+// filter to the returned channel. Subscribers may be added and cancelled at
+// any time, including while the MessageBus is running.
+//
+// Dispatching never waits for a subscriber: events are buffered for each
+// subscriber, and the subscriber's OverflowPolicy applies once its buffer is
+// full. Calling cancel ends the subscription and closes the channel.
+func (mb *MessageBus) Subscribe(
+	filter EventFilter,
+	opts ...SubscribeOption,
+) (
+	<-chan messages.Event,
+	func(),
+) {
+	s := &subscriber{
+		filter: filter,
+		buffer: defaultSubscriptionBuffer,
+		policy: OverflowDropOldest,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.events = make(chan messages.Event, max(s.buffer, 0))
+
+	mb.subscribersMu.Lock()
+	mb.subscribers = append(mb.subscribers, s)
+	mb.subscriberCount.Store(int64(len(mb.subscribers)))
+	mb.subscribersMu.Unlock()
+
+	return s.events, func() { mb.unsubscribe(s) }
+}
+
+func (mb *MessageBus) unsubscribe(s *subscriber) {
+	mb.subscribersMu.Lock()
+	mb.subscribers = slices.DeleteFunc(mb.subscribers, func(other *subscriber) bool {
+		return other == s
+	})
+	mb.subscriberCount.Store(int64(len(mb.subscribers)))
+	mb.subscribersMu.Unlock()
+
+	s.close()
+}
+
+// notifySubscribers delivers the event to any subscribers whose filter
+// selects it, without blocking
+func (mb *MessageBus) notifySubscribers(ctx context.Context, event messages.Event) {
+	if mb.subscriberCount.Load() == 0 {
+		return
+	}
+
+	var disconnected []*subscriber
+
+	mb.subscribersMu.RLock()
+	for _, s := range mb.subscribers {
+		if s.filter != nil && !s.filter(event) {
+			continue
+		}
+
+		if !s.deliver(event) {
+			disconnected = append(disconnected, s)
+		}
+	}
+	mb.subscribersMu.RUnlock()
+
+	for _, s := range disconnected {
+		mb.logger.LogAttrs(ctx, slog.LevelWarn,
+			"disconnecting subscriber that is not keeping up")
+		mb.unsubscribe(s)
+	}
+}
+
+type subscriber struct {
+	filter EventFilter
+	buffer int
+	policy OverflowPolicy
+	events chan messages.Event
+
+	// mu guards sending on and closing events
+	mu     sync.Mutex
+	closed bool
+}
+
+// deliver sends the event to the subscriber, applying its OverflowPolicy if
+// its buffer is full. false is returned if the subscriber must be
+// disconnected.
+func (s *subscriber) deliver(event messages.Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return true
+	}
+
+	select {
+	case s.events <- event:
+		return true
+	default:
+	}
+
+	switch s.policy {
+	case OverflowDropOldest:
+		select {
+		case <-s.events:
+		default:
+		}
+
+		select {
+		case s.events <- event:
+		default:
+		}
+	case OverflowDisconnect:
+		return false
+	}
+
+	return true
+}
+
+func (s *subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	close(s.events)
+}
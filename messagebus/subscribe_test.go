@@ -0,0 +1,112 @@
+package messagebus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+type subscribeCommand struct {
+	messages.BaseCommand
+	Count int
+}
+
+type subscribeEvent struct {
+	messages.BaseEvent
+	N int
+}
+
+type otherSubscribeEvent struct {
+	messages.BaseEvent
+}
+
+func newSubscribeMessageBus(t *testing.T) *messagebus.MessageBus {
+	mb := messagebus.New(messagebus.WithInlineDispatch())
+
+	err := messagebus.RegisterCommandHandler(mb, func(ctx context.Context, cmd *subscribeCommand) ([]messages.Event, error) {
+		events := []messages.Event{&otherSubscribeEvent{}}
+		for n := range cmd.Count {
+			events = append(events, &subscribeEvent{N: n})
+		}
+		return events, nil
+	})
+	require.NoError(t, err)
+
+	return mb
+}
+
+func receiveAll(events <-chan messages.Event) []int {
+	var received []int
+	for {
+		select {
+		case evt, ok := <-events:
+			if !ok {
+				return received
+			}
+			received = append(received, evt.(*subscribeEvent).N)
+		default:
+			return received
+		}
+	}
+}
+
+// Test that subscribers receive the events their filter selects
+func TestSubscribe(t *testing.T) {
+	mb := newSubscribeMessageBus(t)
+
+	events, cancel := mb.Subscribe(messagebus.EventsOfType[*subscribeEvent]())
+
+	err := mb.HandleCommand(context.Background(), &subscribeCommand{Count: 3})
+	require.NoError(t, err)
+
+	require.Equal(t, []int{0, 1, 2}, receiveAll(events))
+
+	cancel()
+
+	_, ok := <-events
+	require.False(t, ok)
+
+	// Cancelled subscribers no longer receive events
+	err = mb.HandleCommand(context.Background(), &subscribeCommand{Count: 1})
+	require.NoError(t, err)
+
+	cancel()
+}
+
+// Test the overflow policies applied to subscribers that aren't keeping up
+func TestSubscribeOverflow(t *testing.T) {
+	tests := []struct {
+		policy   messagebus.OverflowPolicy
+		expected []int
+	}{
+		{messagebus.OverflowDropOldest, []int{3, 4}},
+		{messagebus.OverflowDropNewest, []int{0, 1}},
+		{messagebus.OverflowDisconnect, []int{0, 1}},
+	}
+
+	for _, tt := range tests {
+		mb := newSubscribeMessageBus(t)
+
+		events, cancel := mb.Subscribe(
+			messagebus.EventsOfType[*subscribeEvent](),
+			messagebus.WithSubscriptionBuffer(2),
+			messagebus.WithOverflowPolicy(tt.policy),
+		)
+
+		err := mb.HandleCommand(context.Background(), &subscribeCommand{Count: 5})
+		require.NoError(t, err)
+
+		require.Equal(t, tt.expected, receiveAll(events))
+
+		if tt.policy == messagebus.OverflowDisconnect {
+			_, open := <-events
+			require.False(t, open)
+		}
+
+		cancel()
+	}
+}
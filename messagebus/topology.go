@@ -0,0 +1,266 @@
+package messagebus
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Topology describes the command handlers, event handlers and policies
+// registered with a MessageBus
+type Topology struct {
+	Commands []CommandTopology
+	Events   []EventTopology
+}
+
+// CommandTopology describes the handler of a Command type
+type CommandTopology struct {
+	Type    string
+	Handler HandlerTopology
+}
+
+// EventTopology describes the handlers and policies of an Event type. Event
+// types that handlers declare they emit are included even if nothing handles
+// them.
+type EventTopology struct {
+	Type     string
+	Handlers []HandlerTopology
+	Policies []PolicyTopology
+}
+
+// HandlerTopology describes a handler by its name and the types of the events
+// it declares it emits with WithEmits
+type HandlerTopology struct {
+	Name  string
+	Emits []string
+}
+
+// PolicyTopology describes a policy by its name and the types of the
+// commands it declares it issues with WithIssues
+type PolicyTopology struct {
+	Name   string
+	Issues []string
+}
+
+// Topology returns the Topology of the handlers and policies registered with
+// the MessageBus, ordered by message type
+func (mb *MessageBus) Topology() Topology {
+	var topology Topology
+
+	events := make(map[string]*EventTopology)
+
+	event := func(eventType reflect.Type) *EventTopology {
+		name := messageTypeName(eventType)
+
+		if events[name] == nil {
+			events[name] = &EventTopology{Type: name}
+		}
+
+		return events[name]
+	}
+
+	handler := func(config handlerConfig) HandlerTopology {
+		h := HandlerTopology{Name: config.name}
+
+		for _, eventType := range config.emits {
+			h.Emits = append(h.Emits, messageTypeName(eventType))
+			event(eventType)
+		}
+
+		return h
+	}
+
+	for commandType, commandHandler := range mb.commandHandlers {
+		topology.Commands = append(topology.Commands, CommandTopology{
+			Type:    messageTypeName(commandType),
+			Handler: handler(commandHandler.handlerConfig),
+		})
+	}
+
+	for eventType, eventHandlers := range mb.eventHandlers {
+		for _, eventHandler := range eventHandlers {
+			h := handler(eventHandler.handlerConfig)
+			e := event(eventType)
+			e.Handlers = append(e.Handlers, h)
+		}
+	}
+
+	for eventType, policies := range mb.policies {
+		e := event(eventType)
+
+		for _, policy := range policies {
+			p := PolicyTopology{Name: policy.name}
+
+			for _, commandType := range policy.issues {
+				p.Issues = append(p.Issues, messageTypeName(commandType))
+			}
+
+			e.Policies = append(e.Policies, p)
+		}
+	}
+
+	for _, e := range events {
+		topology.Events = append(topology.Events, *e)
+	}
+
+	slices.SortFunc(topology.Commands, func(a, b CommandTopology) int {
+		return cmp.Compare(a.Type, b.Type)
+	})
+
+	slices.SortFunc(topology.Events, func(a, b EventTopology) int {
+		return cmp.Compare(a.Type, b.Type)
+	})
+
+	return topology
+}
+
+// messageTypeName names a message type without the pointer most messages are
+// implemented with
+func messageTypeName(messageType reflect.Type) string {
+	if messageType.Kind() == reflect.Pointer {
+		messageType = messageType.Elem()
+	}
+	return messageType.String()
+}
+
+type topologyNodeKind int
+
+const (
+	commandNode topologyNodeKind = iota
+	eventNode
+	handlerNode
+	policyNode
+)
+
+type topologyNode struct {
+	id    string
+	label string
+	kind  topologyNodeKind
+}
+
+type topologyEdge struct {
+	from string
+	to   string
+}
+
+// graph lays the Topology out as the command → handler → event → handler or
+// policy → command flows it describes. Each handler gets its own node, as
+// handlers may share a name.
+func (t Topology) graph() ([]topologyNode, []topologyEdge) {
+	var (
+		nodes []topologyNode
+		edges []topologyEdge
+	)
+
+	addNode := func(label string, kind topologyNodeKind) string {
+		id := fmt.Sprintf("n%d", len(nodes))
+		nodes = append(nodes, topologyNode{id: id, label: label, kind: kind})
+		return id
+	}
+
+	eventIDs := make(map[string]string)
+
+	for _, e := range t.Events {
+		eventIDs[e.Type] = addNode(e.Type, eventNode)
+	}
+
+	addHandler := func(from string, h HandlerTopology) {
+		id := addNode(h.Name, handlerNode)
+		edges = append(edges, topologyEdge{from: from, to: id})
+
+		for _, eventType := range h.Emits {
+			edges = append(edges, topologyEdge{from: id, to: eventIDs[eventType]})
+		}
+	}
+
+	commandIDs := make(map[string]string)
+
+	for _, c := range t.Commands {
+		commandIDs[c.Type] = addNode(c.Type, commandNode)
+		addHandler(commandIDs[c.Type], c.Handler)
+	}
+
+	// Commands issued by policies that have no handler still get a node
+	commandID := func(commandType string) string {
+		if commandIDs[commandType] == "" {
+			commandIDs[commandType] = addNode(commandType, commandNode)
+		}
+		return commandIDs[commandType]
+	}
+
+	for _, e := range t.Events {
+		for _, h := range e.Handlers {
+			addHandler(eventIDs[e.Type], h)
+		}
+
+		for _, policy := range e.Policies {
+			id := addNode(policy.Name, policyNode)
+			edges = append(edges, topologyEdge{from: eventIDs[e.Type], to: id})
+
+			for _, commandType := range policy.Issues {
+				edges = append(edges, topologyEdge{from: id, to: commandID(commandType)})
+			}
+		}
+	}
+
+	return nodes, edges
+}
+
+// DOT renders the Topology as a Graphviz DOT digraph
+func (t Topology) DOT() string {
+	nodes, edges := t.graph()
+
+	shapes := map[topologyNodeKind]string{
+		commandNode: "box",
+		eventNode:   "note",
+		handlerNode: "ellipse",
+		policyNode:  "diamond",
+	}
+
+	var b strings.Builder
+
+	b.WriteString("digraph messagebus {\n")
+	b.WriteString("\trankdir=LR;\n")
+
+	for _, node := range nodes {
+		fmt.Fprintf(&b, "\t%s [label=%q, shape=%s];\n", node.id, node.label, shapes[node.kind])
+	}
+
+	for _, edge := range edges {
+		fmt.Fprintf(&b, "\t%s -> %s;\n", edge.from, edge.to)
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// Mermaid renders the Topology as a Mermaid flowchart
+func (t Topology) Mermaid() string {
+	nodes, edges := t.graph()
+
+	shapes := map[topologyNodeKind][2]string{
+		commandNode: {"[", "]"},
+		eventNode:   {"{{", "}}"},
+		handlerNode: {"([", "])"},
+		policyNode:  {"{", "}"},
+	}
+
+	var b strings.Builder
+
+	b.WriteString("flowchart LR\n")
+
+	for _, node := range nodes {
+		shape := shapes[node.kind]
+		label := strings.ReplaceAll(node.label, `"`, "#quot;")
+		fmt.Fprintf(&b, "\t%s%s\"%s\"%s\n", node.id, shape[0], label, shape[1])
+	}
+
+	for _, edge := range edges {
+		fmt.Fprintf(&b, "\t%s --> %s\n", edge.from, edge.to)
+	}
+
+	return b.String()
+}
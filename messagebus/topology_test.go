@@ -0,0 +1,145 @@
+package messagebus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmpettyp/dorky/messagebus"
+	"github.com/dmpettyp/dorky/messages"
+)
+
+func newTopologyMessageBus(t *testing.T) *messagebus.MessageBus {
+	mb := messagebus.New()
+
+	svc := &OrderService{}
+
+	err := messagebus.RegisterCommandHandler(mb, svc.HandleCreateOrder,
+		messagebus.WithName("create order"),
+		messagebus.WithEmits[*OrderCreatedEvent](),
+	)
+	require.NoError(t, err)
+
+	err = messagebus.RegisterCommandHandler(mb, svc.HandleShipOrder,
+		messagebus.WithEmits[*OrderShippedEvent](),
+	)
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(mb, svc.OnOrderCreated,
+		messagebus.WithName("count created orders"),
+	)
+	require.NoError(t, err)
+
+	err = messagebus.RegisterPolicy(mb, func(ctx context.Context, evt *OrderCreatedEvent) ([]messages.Command, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	err = messagebus.RegisterPolicy(mb, func(ctx context.Context, evt *OrderCreatedEvent) ([]messages.Command, error) {
+		return nil, nil
+	},
+		messagebus.WithName("ship created orders"),
+		messagebus.WithIssues[*ShipOrderCommand](),
+	)
+	require.NoError(t, err)
+
+	return mb
+}
+
+// Test that the topology lists every command and event type with its handlers
+func TestTopology(t *testing.T) {
+	mb := newTopologyMessageBus(t)
+
+	topology := mb.Topology()
+
+	require.Equal(t, []messagebus.CommandTopology{
+		{
+			Type: "messagebus_test.CreateOrderCommand",
+			Handler: messagebus.HandlerTopology{
+				Name:  "create order",
+				Emits: []string{"messagebus_test.OrderCreatedEvent"},
+			},
+		},
+		{
+			Type: "messagebus_test.ShipOrderCommand",
+			Handler: messagebus.HandlerTopology{
+				Name:  "github.com/dmpettyp/dorky/messagebus_test.(*OrderService).HandleShipOrder",
+				Emits: []string{"messagebus_test.OrderShippedEvent"},
+			},
+		},
+	}, topology.Commands)
+
+	require.Len(t, topology.Events, 2)
+
+	created := topology.Events[0]
+	require.Equal(t, "messagebus_test.OrderCreatedEvent", created.Type)
+	require.Equal(t, []messagebus.HandlerTopology{{Name: "count created orders"}}, created.Handlers)
+	require.Len(t, created.Policies, 2)
+	require.Contains(t, created.Policies[0].Name, "newTopologyMessageBus.func")
+	require.Equal(t, messagebus.PolicyTopology{
+		Name:   "ship created orders",
+		Issues: []string{"messagebus_test.ShipOrderCommand"},
+	}, created.Policies[1])
+
+	// Emitted events without handlers are included
+	shipped := topology.Events[1]
+	require.Equal(t, "messagebus_test.OrderShippedEvent", shipped.Type)
+	require.Empty(t, shipped.Handlers)
+}
+
+// Test exporting the topology as Graphviz DOT and Mermaid diagrams
+func TestTopologyGraphs(t *testing.T) {
+	mb := messagebus.New()
+
+	err := messagebus.RegisterCommandHandler(mb, (&OrderService{}).HandleCreateOrder,
+		messagebus.WithName("create order"),
+		messagebus.WithEmits[*OrderCreatedEvent](),
+	)
+	require.NoError(t, err)
+
+	err = messagebus.RegisterEventHandler(mb, (&OrderService{}).OnOrderCreated,
+		messagebus.WithName(`count "created" orders`),
+	)
+	require.NoError(t, err)
+
+	err = messagebus.RegisterPolicy(mb, func(ctx context.Context, evt *OrderCreatedEvent) ([]messages.Command, error) {
+		return nil, nil
+	},
+		messagebus.WithName("ship created orders"),
+		messagebus.WithIssues[*ShipOrderCommand](),
+	)
+	require.NoError(t, err)
+
+	topology := mb.Topology()
+
+	require.Equal(t, `digraph messagebus {
+	rankdir=LR;
+	n0 [label="messagebus_test.OrderCreatedEvent", shape=note];
+	n1 [label="messagebus_test.CreateOrderCommand", shape=box];
+	n2 [label="create order", shape=ellipse];
+	n3 [label="count \"created\" orders", shape=ellipse];
+	n4 [label="ship created orders", shape=diamond];
+	n5 [label="messagebus_test.ShipOrderCommand", shape=box];
+	n1 -> n2;
+	n2 -> n0;
+	n0 -> n3;
+	n0 -> n4;
+	n4 -> n5;
+}
+`, topology.DOT())
+
+	require.Equal(t, `flowchart LR
+	n0{{"messagebus_test.OrderCreatedEvent"}}
+	n1["messagebus_test.CreateOrderCommand"]
+	n2(["create order"])
+	n3(["count #quot;created#quot; orders"])
+	n4{"ship created orders"}
+	n5["messagebus_test.ShipOrderCommand"]
+	n1 --> n2
+	n2 --> n0
+	n0 --> n3
+	n0 --> n4
+	n4 --> n5
+`, topology.Mermaid())
+}
@@ -16,13 +16,19 @@ var NewCommandID, MustNewCommandID, ParseCommandID = id.Create(
 // must implement
 type Command interface {
 	isCommand()
+	GetID() CommandID
 	GetType() string
+	GetCausationID() id.ID
+	GetCorrelationID() id.ID
+	SetCausation(causationID id.ID, correlationID id.ID)
 }
 
 type BaseCommand struct {
-	ID        CommandID `json:"id"`
-	Type      string    `json:"type"`
-	Timestamp time.Time `json:"timestamp"`
+	ID            CommandID `json:"id"`
+	Type          string    `json:"type"`
+	Timestamp     time.Time `json:"timestamp"`
+	CausationID   id.ID     `json:"causation_id,omitzero"`
+	CorrelationID id.ID     `json:"correlation_id,omitzero"`
 }
 
 // BaseCommand must implement isCommand to be recognized as a dorky Command
@@ -37,3 +43,26 @@ func (c *BaseCommand) Init(commandType string) {
 func (c *BaseCommand) GetType() string {
 	return c.Type
 }
+
+func (c *BaseCommand) GetID() CommandID {
+	return c.ID
+}
+
+// GetCausationID returns the ID of the message that caused this command to be
+// issued, or a nil ID if the command was issued directly by a client
+func (c *BaseCommand) GetCausationID() id.ID {
+	return c.CausationID
+}
+
+// GetCorrelationID returns the ID of the command that started the chain of
+// messages this command belongs to
+func (c *BaseCommand) GetCorrelationID() id.ID {
+	return c.CorrelationID
+}
+
+// SetCausation links the command to the message that caused it and the chain
+// of messages it belongs to
+func (c *BaseCommand) SetCausation(causationID id.ID, correlationID id.ID) {
+	c.CausationID = causationID
+	c.CorrelationID = correlationID
+}
@@ -20,21 +20,27 @@ type Event interface {
 	isEvent()
 	IsInitialized() bool
 	SetEntity(entityType string, entityID id.ID)
+	SetCausation(causationID id.ID, correlationID id.ID)
+	GetID() EventID
 	GetType() string
 	GetTimestamp() time.Time
 	GetEntityID() id.ID
 	GetEntityType() string
+	GetCausationID() id.ID
+	GetCorrelationID() id.ID
 }
 
 // BaseEvent provides an implementation of much of the Event interface which
 // can be embedded in specific domain Events defined within client applications
 type BaseEvent struct {
-	ID          EventID   `json:"id"`
-	Type        string    `json:"type"`
-	Timestamp   time.Time `json:"timestamp"`
-	EntityType  string    `json:"entity_type"`
-	EntityID    id.ID     `json:"entity_id"`
-	initialized bool
+	ID            EventID   `json:"id"`
+	Type          string    `json:"type"`
+	Timestamp     time.Time `json:"timestamp"`
+	EntityType    string    `json:"entity_type"`
+	EntityID      id.ID     `json:"entity_id"`
+	CausationID   id.ID     `json:"causation_id,omitzero"`
+	CorrelationID id.ID     `json:"correlation_id,omitzero"`
+	initialized   bool
 }
 
 // BaseEvent must implement isEvent to be recognized as a dorky Event
@@ -54,10 +60,21 @@ func (e *BaseEvent) SetEntity(entityType string, entityID id.ID) {
 	e.EntityID = entityID
 }
 
+// SetCausation links the event to the message that caused it and the chain of
+// messages it belongs to
+func (e *BaseEvent) SetCausation(causationID id.ID, correlationID id.ID) {
+	e.CausationID = causationID
+	e.CorrelationID = correlationID
+}
+
 func (e *BaseEvent) IsInitialized() bool {
 	return e.initialized
 }
 
+func (e *BaseEvent) GetID() EventID {
+	return e.ID
+}
+
 func (e *BaseEvent) GetType() string {
 	return e.Type
 }
@@ -73,3 +90,14 @@ func (e *BaseEvent) GetEntityID() id.ID {
 func (e *BaseEvent) GetEntityType() string {
 	return e.EntityType
 }
+
+// GetCausationID returns the ID of the message that caused this event
+func (e *BaseEvent) GetCausationID() id.ID {
+	return e.CausationID
+}
+
+// GetCorrelationID returns the ID of the command that started the chain of
+// messages this event belongs to
+func (e *BaseEvent) GetCorrelationID() id.ID {
+	return e.CorrelationID
+}
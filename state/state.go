@@ -3,6 +3,8 @@ package state
 import (
 	"fmt"
 	"slices"
+
+	dorkyerrors "github.com/dmpettyp/dorky/errors"
 )
 
 type stateType[st ~string | ~int] interface {
@@ -18,9 +20,10 @@ func NewState[T stateType[T]](s T) (State[T], error) {
 	state := State[T]{}
 
 	if _, valid := s.Transitions()[s]; !valid {
-		return state, fmt.Errorf(
-			"could not create state: invalid initial state %q", s,
-		)
+		return state, dorkyerrors.NewInvalid(
+			"invalid_state",
+			fmt.Sprintf("could not create state: invalid initial state %q", s),
+		).WithDetail("state", s)
 	}
 
 	state.current = s
@@ -32,16 +35,22 @@ func (state *State[T]) Transition(to T) error {
 	transitions, ok := to.Transitions()[state.current]
 
 	if !ok {
-		return fmt.Errorf(
-			"cannot transition state: invalid current state %q", state.current,
-		)
+		return dorkyerrors.NewInternal(
+			"invalid_state",
+			fmt.Sprintf(
+				"cannot transition state: invalid current state %q", state.current,
+			),
+		).WithDetail("state", state.current)
 	}
 
 	if !slices.Contains(transitions, to) {
-		return fmt.Errorf(
-			"cannot transition state from %q to %q, invalid transition",
-			state.current, to,
-		)
+		return dorkyerrors.NewConflict(
+			"invalid_state_transition",
+			fmt.Sprintf(
+				"cannot transition state from %q to %q, invalid transition",
+				state.current, to,
+			),
+		).WithDetail("from", state.current).WithDetail("to", to)
 	}
 
 	state.current = to